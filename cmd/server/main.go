@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"nas-renamer/design"
 	"nas-renamer/internal/api"
 	"nas-renamer/internal/middleware"
 	"os"
@@ -28,7 +29,10 @@ func main() {
 		staticDir = envStatic
 	}
 
-	handler, err := api.NewHandler(rootDir)
+	// History location, mount it as a volume in Docker so it survives upgrades
+	historyDir := os.Getenv("HISTORY_DIR")
+
+	handler, err := api.NewHandler(rootDir, historyDir)
 	if err != nil {
 		log.Fatalf("Failed to initialize handler: %v", err)
 	}
@@ -48,7 +52,7 @@ func main() {
 	// API Group
 	apiGroup := r.Group("/api")
 	{
-		apiGroup.POST("/login", handler.HandleLogin)
+		apiGroup.POST("/login", handler.Audit(design.AuditLogin), handler.HandleLogin)
 
 		// Authenticated Routes
 		authorized := apiGroup.Group("/")
@@ -56,14 +60,52 @@ func main() {
 		{
 			authorized.GET("/files", handler.HandleListFiles)
 			authorized.POST("/rename/preview", handler.HandlePreview)
-			authorized.POST("/rename/execute", handler.HandleExecute)
+			authorized.POST("/rename/preview/stream", handler.HandlePreviewStream)
+			authorized.POST("/rename/execute", handler.Audit(design.AuditExecute), handler.HandleExecute)
 			authorized.GET("/history", handler.HandleGetHistory)
-			authorized.POST("/history/undo", handler.HandleUndo)
+			authorized.POST("/history/undo", handler.Audit(design.AuditUndo), handler.HandleUndo)
+			authorized.POST("/history/undo/preview", handler.HandleUndoPreview)
+			authorized.POST("/history/redo", handler.Audit(design.AuditRedo), handler.HandleRedo)
+			authorized.POST("/history/redo/preview", handler.HandleRedoPreview)
+			authorized.GET("/history/:id/lineage", handler.HandleGetLineage)
+			authorized.GET("/history/file", handler.HandleFileLineage)
+			authorized.POST("/history/file/restore", handler.Audit(design.AuditRestore), handler.HandleRestoreFile)
+			authorized.POST("/history/:id/pin", handler.Audit(design.AuditHistory), handler.HandlePinHistory)
+
+			// Batches cut short by a crash
+			authorized.GET("/recovery", handler.HandleListPending)
+			authorized.POST("/recovery/:id/complete", handler.Audit(design.AuditRecovery), handler.HandleCompletePending)
+			authorized.POST("/recovery/:id/rollback", handler.Audit(design.AuditRecovery), handler.HandleRollbackPending)
+
+			// History administration
+			authorized.GET("/admin/history/export", handler.Audit(design.AuditHistory), handler.HandleExportHistory)
+			authorized.DELETE("/admin/history", handler.Audit(design.AuditHistory), handler.HandleDeleteHistory)
+			authorized.POST("/admin/history/prune", handler.Audit(design.AuditHistory), handler.HandlePruneHistory)
+			authorized.GET("/admin/audit", handler.HandleGetAudit)
+			authorized.GET("/admin/audit/verify", handler.HandleVerifyAudit)
 
 			// Optimizations
 			authorized.GET("/config/ignored-extensions", handler.HandleGetConfig)
-			authorized.POST("/config/ignored-extensions", handler.HandleSetConfig)
+			authorized.POST("/config/ignored-extensions", handler.Audit(design.AuditConfig), handler.HandleSetConfig)
 			authorized.GET("/scan/frequent-strings", handler.HandleScanFrequent)
+			authorized.GET("/config/junk-tokens", handler.HandleGetJunkTokens)
+			authorized.POST("/config/junk-tokens", handler.Audit(design.AuditConfig), handler.HandleSetJunkTokens)
+			authorized.POST("/config/junk-tokens/promote", handler.Audit(design.AuditConfig), handler.HandlePromoteJunk)
+			authorized.GET("/config/scene-tags", handler.HandleGetSceneTags)
+			authorized.POST("/config/scene-tags", handler.Audit(design.AuditConfig), handler.HandleSetSceneTags)
+			authorized.GET("/config/ad-tlds", handler.HandleGetAdTLDs)
+			authorized.POST("/config/ad-tlds", handler.Audit(design.AuditConfig), handler.HandleSetAdTLDs)
+			authorized.GET("/config/settings", handler.HandleGetSettings)
+			authorized.POST("/config/settings", handler.Audit(design.AuditConfig), handler.HandleSetSettings)
+
+			// Presets
+			authorized.GET("/presets", handler.HandleListPresets)
+			authorized.POST("/presets", handler.Audit(design.AuditPreset), handler.HandleCreatePreset)
+			authorized.PUT("/presets/:id", handler.Audit(design.AuditPreset), handler.HandleUpdatePreset)
+			authorized.DELETE("/presets/:id", handler.Audit(design.AuditPreset), handler.HandleDeletePreset)
+			authorized.POST("/presets/:id/duplicate", handler.Audit(design.AuditPreset), handler.HandleDuplicatePreset)
+			authorized.GET("/presets/export", handler.HandleExportPresets)
+			authorized.POST("/presets/import", handler.Audit(design.AuditPreset), handler.HandleImportPresets)
 		}
 	}
 
@@ -1,6 +1,9 @@
 package design
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // @author weifengl
 
@@ -16,42 +19,209 @@ type LoginResponse struct {
 
 // Rename
 const (
-	ModeQuick = "quick"
-	ModeBasic = "basic"
+	ModeQuick    = "quick"
+	ModeBasic    = "basic"
+	ModeAdvanced = "advanced" // Same as basic: custom rules only
+	ModeRestore  = "restore"  // History entry of a FileRestoreRequest
 )
 
+// MaxIndexWidth is the widest {index:N} a template may pad to, the same
+// limit as the pad builtin of scripts.
+const MaxIndexWidth = 32
+
 type RenameRequest struct {
-	DirPath     string         `json:"dir_path" binding:"required"`
-	Mode        string         `json:"mode" binding:"required"` // quick, basic
-	QuickRules  QuickOptions   `json:"quick_rules"`
-	CustomRules []RenameRule   `json:"custom_rules"`
-	TargetPaths []string       `json:"target_paths"` // Optional specific files
-	DryRun      bool           `json:"dry_run"`
+	DirPath     string           `json:"dir_path" binding:"required"`
+	Mode        string           `json:"mode"` // quick, basic. Required unless PresetID is set
+	QuickRules  QuickOptions     `json:"quick_rules"`
+	CustomRules []RenameRule     `json:"custom_rules"`
+	Template    string           `json:"template"` // Optional, e.g. "{name} - {index}{ext}"
+	Filters     FileFilter       `json:"filters"`
+	TargetPaths []string         `json:"target_paths"` // Optional specific files
+	DryRun      bool             `json:"dry_run"`
+	Trace       bool             `json:"trace,omitempty"`                                   // Preview only: return per-rule intermediate names
+	OnConflict  string           `json:"on_conflict" binding:"omitempty,oneof=skip suffix"` // See Conflict* constants, empty means skip
+	Atomic      bool             `json:"atomic"`                                            // All or nothing: a failed rename rolls back the others
+	PresetID    string           `json:"preset_id"`                                         // Optional, rules are loaded from the preset
+	Overrides   *PresetOverrides `json:"overrides"`                                         // Optional, applied on top of the preset
 }
 
+// Conflict strategies, for names taken on disk or within the batch.
+// Renames never replace existing files.
+const (
+	ConflictSkip   = "skip"   // Leave the file alone and report a conflict
+	ConflictSuffix = "suffix" // Use the first free "name (n).ext"
+)
+
 type QuickOptions struct {
 	RemoveBrackets   bool `json:"remove_brackets"`
 	RemoveParens     bool `json:"remove_parens"`
 	RemoveURL        bool `json:"remove_url"`
 	NormalizeDelim   bool `json:"normalize_delim"`
 	ProtectExtension bool `json:"protect_extension"`
+	RemoveKnownJunk  bool `json:"remove_known_junk"` // Apply the junk token dictionary
+
+	// Release scene noise, word lists are editable (see SceneTag* categories)
+	RemoveResolution  bool `json:"remove_resolution"`   // 1080p, 4K
+	RemoveSource      bool `json:"remove_source"`       // WEB-DL, BluRay
+	RemoveCodec       bool `json:"remove_codec"`        // x265, HEVC, AAC
+	RemoveHDR         bool `json:"remove_hdr"`          // HDR10, DV
+	RemoveGroup       bool `json:"remove_group"`        // Known groups and trailing -GRP
+	RemoveChineseTags bool `json:"remove_chinese_tags"` // 国语中字, 高清
+
+	// Smart brackets: classify bracket contents and only remove the selected
+	// Bracket* categories. When set, RemoveBrackets and RemoveParens are ignored.
+	SmartBrackets     bool     `json:"smart_brackets"`
+	BracketCategories []string `json:"bracket_categories"`
+}
+
+// Bracket content categories for smart bracket removal
+const (
+	BracketYear       = "year"       // (2009)
+	BracketEpisode    = "episode"    // [01], [EP01], [第01集]
+	BracketResolution = "resolution" // [1080p], [1920x1080], [HEVC 1080p]
+	BracketCRC        = "crc"        // [ABCD1234]
+	BracketGroup      = "group"      // Leading [SubsPlease]
+	BracketLanguage   = "language"   // [CHS], [简繁], [中英双语]
+	BracketAd         = "ad"         // URLs and known junk
+	BracketOther      = "other"
+)
+
+// Scene tag categories
+const (
+	SceneTagResolution = "resolution"
+	SceneTagSource     = "source"
+	SceneTagCodec      = "codec"
+	SceneTagHDR        = "hdr"
+	SceneTagGroup      = "group"
+	SceneTagChinese    = "chinese"
+)
+
+var SceneTagCategories = []string{
+	SceneTagResolution, SceneTagSource, SceneTagCodec, SceneTagHDR, SceneTagGroup, SceneTagChinese,
+}
+
+// FileFilter narrows down which files of a directory a rename applies to.
+// Patterns are shell globs matched against the file name.
+type FileFilter struct {
+	Include    []string `json:"include"`
+	Exclude    []string `json:"exclude"`
+	Extensions []string `json:"extensions"` // Empty means all extensions
+}
+
+// Rule types
+const (
+	RuleReplace = "replace"
+	RuleRegex   = "regex"
+	RulePrefix  = "prefix"
+	RuleSuffix  = "suffix"
+	RuleScript  = "script" // Target holds the script source, see internal/script
+
+	// Positional rules work on runes of the name without its extension
+	RuleInsert      = "insert"       // Insert Target at Start
+	RuleDelete      = "delete"       // Delete Count runes from Start (0 = nothing)
+	RuleKeep        = "keep"         // Keep Count runes from Start (0 = to the end)
+	RuleRemoveWords = "remove_words" // Remove the first (or last) Count words
+)
+
+// KnownRuleTypes lists every rule type the engine understands.
+var KnownRuleTypes = []string{
+	RuleReplace, RuleRegex, RulePrefix, RuleSuffix, RuleScript,
+	RuleInsert, RuleDelete, RuleKeep, RuleRemoveWords,
 }
 
 type RenameRule struct {
-	Type        string `json:"type"` // replace, regex, prefix, suffix
+	Type        string `json:"type"` // See Rule* constants
 	Target      string `json:"target"`
 	Replacement string `json:"replacement"`
+
+	// Positional rules only
+	Start   int  `json:"start,omitempty"`
+	Count   int  `json:"count,omitempty"`
+	FromEnd bool `json:"from_end,omitempty"` // Start (or words) counted from the end
 }
 
 type PreviewResponse struct {
 	Items []PreviewItem `json:"items"`
+
+	// Set when the preview was paginated or filtered, see PreviewQuery
+	Total   int             `json:"total,omitempty"` // Items matching the filter, across all pages
+	Offset  int             `json:"offset,omitempty"`
+	Summary *PreviewSummary `json:"summary,omitempty"` // Counts over the whole batch, ignoring the filter
+
+	RuleStats []RuleStat `json:"rule_stats,omitempty"` // Set when the request asked for a trace
+}
+
+// PreviewQuery selects a page of a preview. The zero value returns everything.
+type PreviewQuery struct {
+	Offset  int    `form:"offset"`
+	Limit   int    `form:"limit"`   // 0 means no limit
+	Status  string `form:"status"`  // Only items with this status
+	Changed bool   `form:"changed"` // Only items whose name changes
+}
+
+// PreviewSummary counts preview items by status.
+type PreviewSummary struct {
+	Total    int `json:"total"`
+	Changed  int `json:"changed"`
+	OK       int `json:"ok"`
+	Conflict int `json:"conflict"`
+	Skipped  int `json:"skipped"`
+	Error    int `json:"error"`
+}
+
+// PreviewEvent is one line of a streamed (NDJSON) preview. Items are sent as
+// they are computed, with a running summary every so often and a final one
+// carrying Done.
+type PreviewEvent struct {
+	Item      *PreviewItem    `json:"item,omitempty"`
+	Summary   *PreviewSummary `json:"summary,omitempty"`
+	Done      bool            `json:"done,omitempty"`
+	RuleStats []RuleStat      `json:"rule_stats,omitempty"` // With Done, when the request asked for a trace
+	Error     string          `json:"error,omitempty"`
 }
 
 type PreviewItem struct {
-	OriginalName string `json:"original_name"`
-	NewName      string `json:"new_name"`
-	Status       string `json:"status"` // ok, conflict, skipped
-	Message      string `json:"message"`
+	OriginalName string      `json:"original_name"`
+	NewName      string      `json:"new_name"`
+	Status       string      `json:"status"` // ok, conflict, skipped, error
+	Message      string      `json:"message"`
+	Notes        []string    `json:"notes,omitempty"` // Explains individual changes, e.g. smart bracket removals
+	Diff         []DiffSpan  `json:"diff,omitempty"`  // How OriginalName turns into NewName, empty if unchanged
+	Trace        []TraceStep `json:"trace,omitempty"` // Set when the request asked for a trace
+}
+
+// TraceStep is the name of a file after one step of the rename.
+// Steps are custom rules, enabled quick options and the template, in the order they run.
+type TraceStep struct {
+	Rule    string `json:"rule"`    // Rule type, quick option or "template"
+	Index   int    `json:"index"`   // Position in CustomRules, -1 otherwise
+	Name    string `json:"name"`    // Name after the step
+	Changed bool   `json:"changed"` // False if the step was a no-op
+}
+
+// RuleStat counts the files a step of the rename changed.
+// A step that never changes anything is a candidate for removal from a preset.
+type RuleStat struct {
+	Rule     string `json:"rule"`
+	Index    int    `json:"index"`
+	Affected int    `json:"affected"`
+}
+
+// Diff operations
+const (
+	DiffKeep   = "keep"
+	DiffDelete = "delete"
+	DiffInsert = "insert"
+)
+
+// DiffSpan is a run of runes kept, deleted from the original name or inserted
+// into the new one. Offsets count runes, not bytes. Old is the position in
+// OriginalName and New the position in NewName where the span starts.
+type DiffSpan struct {
+	Op   string `json:"op"` // See Diff* constants
+	Text string `json:"text"`
+	Old  int    `json:"old"`
+	New  int    `json:"new"`
 }
 
 type ExecuteResponse struct {
@@ -59,6 +229,10 @@ type ExecuteResponse struct {
 	SuccessCount int      `json:"success_count"`
 	FailCount    int      `json:"fail_count"`
 	Errors       []string `json:"errors"`
+
+	// Atomic batches only
+	RolledBack     bool     `json:"rolled_back,omitempty"`     // A rename failed and every rename of the batch was undone
+	RollbackErrors []string `json:"rollback_errors,omitempty"` // Renames that could not be undone, kept in history
 }
 
 // History
@@ -67,17 +241,256 @@ type HistoryLog struct {
 	Timestamp int64         `json:"timestamp"`
 	BasePath  string        `json:"base_path"`
 	Mode      string        `json:"mode"`
+	Status    string        `json:"status,omitempty"`    // See Batch* constants, empty means applied
+	Kind      string        `json:"kind,omitempty"`      // See Kind* constants, empty means execute
+	ParentID  string        `json:"parent_id,omitempty"` // Batch an undo or redo acted on
+	Pinned    bool          `json:"pinned,omitempty"`    // Never removed by retention
 	Items     []HistoryItem `json:"items"`
 }
 
+// History entry kinds. Undo and redo entries record the renames they did,
+// from the name before the operation to the name after it.
+const (
+	KindExecute = "execute"
+	KindUndo    = "undo"
+	KindRedo    = "redo"
+)
+
+// HistoryLineage is a batch with the undo and redo entries that acted on it, oldest first.
+type HistoryLineage struct {
+	Batch  *HistoryLog   `json:"batch"`
+	Events []*HistoryLog `json:"events"`
+}
+
+// PendingBatch is an execute that was cut short, by a crash or a kill, before
+// its history was saved. It is found from the intent log on startup and can
+// be completed or rolled back.
+type PendingBatch struct {
+	ID        string        `json:"id"`
+	Timestamp int64         `json:"timestamp"`
+	BasePath  string        `json:"base_path"`
+	Mode      string        `json:"mode"`
+	Items     []PendingItem `json:"items"`
+}
+
+// PendingItem is a planned rename of a pending batch and what happened to it.
+type PendingItem struct {
+	OriginalName string `json:"original_name"`
+	NewName      string `json:"new_name"`
+	State        string `json:"state"` // See Pending* constants
+}
+
+// Pending item states, found by looking at the files
+const (
+	PendingRenamed    = "renamed"
+	PendingNotRenamed = "not_renamed"
+	PendingUnknown    = "unknown" // Neither name holds the file
+)
+
+// FileLineage is every rename of one file found in the history, oldest first.
+// Entries are matched by inode and size where both were recorded, otherwise
+// by following the names back from the current path.
+type FileLineage struct {
+	Path    string       `json:"path"`
+	Size    int64        `json:"size"`
+	Inode   uint64       `json:"inode,omitempty"`
+	Renames []FileRename `json:"renames"`
+}
+
+// FileRename is one rename of a file by a history entry.
+type FileRename struct {
+	BatchID   string `json:"batch_id"`
+	Kind      string `json:"kind"` // See Kind* constants
+	Timestamp int64  `json:"timestamp"`
+	Dir       string `json:"dir"` // Base path of the entry
+	From      string `json:"from"`
+	To        string `json:"to"`
+}
+
+// FileRestoreRequest renames a file back to a name from its lineage, in the
+// directory it is in now. The rename is saved as a history entry and can be
+// undone like any batch.
+type FileRestoreRequest struct {
+	Path       string `json:"path" binding:"required"`
+	Name       string `json:"name" binding:"required"`
+	OnConflict string `json:"on_conflict" binding:"omitempty,oneof=skip suffix"` // See Conflict* constants, empty means skip
+}
+
+// HistoryQuery filters and pages the history. The zero value lists every
+// entry, newest first.
+type HistoryQuery struct {
+	Path   string `form:"path"`                                                                        // Base path prefix, matched by whole directories
+	Name   string `form:"name"`                                                                        // Case-insensitive substring of an original, new or restored name
+	From   int64  `form:"from" binding:"min=0"`                                                        // Unix seconds, inclusive
+	To     int64  `form:"to" binding:"min=0"`                                                          // Unix seconds, inclusive, 0 means no limit
+	Mode   string `form:"mode"`                                                                        // See Mode* constants
+	Kind   string `form:"kind" binding:"omitempty,oneof=execute undo redo"`                            // See Kind* constants
+	Status string `form:"status" binding:"omitempty,oneof=applied partially_reverted reverted undone"` // A Batch* value, or undone for any undone item
+	Cursor string `form:"cursor"`                                                                      // X-Next-Cursor of the previous page
+	Offset int    `form:"offset" binding:"min=0"`
+	Limit  int    `form:"limit" binding:"min=0"` // 0 means no limit
+	Count  bool   `form:"count"`                 // Count every match with path, name, mode, kind or status filters, which reads the whole history
+}
+
+// StatusUndone matches batches with at least one undone item in a HistoryQuery.
+const StatusUndone = "undone"
+
+// Batch statuses, derived from the states of its items
+const (
+	BatchApplied           = "applied"
+	BatchPartiallyReverted = "partially_reverted"
+	BatchReverted          = "reverted"
+)
+
+// History item states
+const (
+	ItemApplied  = "applied"
+	ItemReverted = "reverted"
+)
+
 type HistoryItem struct {
 	OriginalName string `json:"original_name"`
 	NewName      string `json:"new_name"`
 	Size         int64  `json:"size"`
+	State        string `json:"state,omitempty"`       // See Item* constants, empty means applied
+	RestoredAs   string `json:"restored_as,omitempty"` // Name undo restored instead of a taken OriginalName
+
+	// Identity of the file at execute time, checked before undo. Zero if unknown.
+	ModTime int64  `json:"mod_time,omitempty"` // Unix nanoseconds
+	Dev     uint64 `json:"dev,omitempty"`
+	Inode   uint64 `json:"inode,omitempty"`
+	Hash    string `json:"hash,omitempty"` // Partial content hash, see Settings.HashOnExecute
 }
 
 type UndoRequest struct {
-	BatchID string `json:"batch_id" binding:"required"`
+	BatchID    string   `json:"batch_id" binding:"required"`
+	Items      []string `json:"items"`                                             // Optional NewName of the items to undo, empty means all
+	OnConflict string   `json:"on_conflict" binding:"omitempty,oneof=skip suffix"` // See Conflict* constants, empty means skip
+}
+
+// Undo (and redo) preview statuses. Only restorable items are renamed.
+const (
+	UndoRestorable    = "restorable"
+	UndoSourceMissing = "source_missing"  // The renamed file is gone
+	UndoTargetTaken   = "target_taken"    // Another file now has the original name
+	UndoSizeChanged   = "size_changed"    // The renamed file may have been replaced
+	UndoModified      = "modified"        // Modification time changed
+	UndoReplaced      = "replaced"        // Different device or inode
+	UndoHashChanged   = "content_changed" // Partial content hash changed
+	UndoReverted      = "reverted"        // Already undone, skipped
+	UndoApplied       = "applied"         // Redo only: never undone, skipped
+)
+
+type UndoPreviewItem struct {
+	OriginalName string `json:"original_name"`
+	NewName      string `json:"new_name"` // Name after the rename. Undo restores OriginalName, redo NewName
+	Status       string `json:"status"`   // See Undo* constants
+	Message      string `json:"message"`
+	RestoreAs    string `json:"restore_as,omitempty"` // Free name used instead of a taken one, with the suffix strategy
+
+	// Identity checks that failed: size, mtime, inode, hash. With the warn
+	// policy the item stays restorable and these are only reported.
+	Mismatches []string `json:"mismatches,omitempty"`
+}
+
+// Undo verification policies
+const (
+	VerifyStrict = "strict" // Any identity mismatch blocks the item
+	VerifyWarn   = "warn"   // Mismatches are reported, the item is restored anyway
+	VerifyIgnore = "ignore" // Only check that the file exists
+)
+
+// Settings are global options, stored in data/settings.json.
+type Settings struct {
+	UndoVerification string `json:"undo_verification"` // See Verify* constants
+	HashOnExecute    bool   `json:"hash_on_execute"`   // Record a partial content hash of renamed files
+
+	HistoryRetention RetentionPolicy `json:"history_retention"`
+}
+
+// RetentionPolicy limits how much history is kept. Zero disables a limit.
+// A batch is removed together with its undo and redo entries, and pinned
+// batches are always kept.
+type RetentionPolicy struct {
+	MaxAgeDays int `json:"max_age_days"` // Counted from the latest undo or redo
+	MaxCount   int `json:"max_count"`    // Batches, not counting undo and redo entries
+	MaxSizeMB  int `json:"max_size_mb"`
+}
+
+type PinRequest struct {
+	Pinned bool `json:"pinned"`
+}
+
+type UndoPreviewResponse struct {
+	BatchID string            `json:"batch_id"`
+	Items   []UndoPreviewItem `json:"items"`
+}
+
+// Junk token dictionary
+type JunkToken struct {
+	ID       string `json:"id"`
+	Pattern  string `json:"pattern"`
+	Regex    bool   `json:"regex"`    // Pattern is a regex, otherwise a case-insensitive literal
+	Category string `json:"category"` // Free form, e.g. ad, site, group
+	Enabled  bool   `json:"enabled"`
+}
+
+type PromoteJunkRequest struct {
+	Tokens   []string `json:"tokens"`   // Tokens to add. If empty, DirPath is analyzed
+	DirPath  string   `json:"dir_path"` // Directory to run the frequent strings analyzer on
+	Category string   `json:"category"`
+}
+
+// Presets
+type Preset struct {
+	ID          string       `json:"id,omitempty"`
+	Name        string       `json:"name"`
+	Mode        string       `json:"mode"`
+	QuickRules  QuickOptions `json:"quick_rules"`
+	CustomRules []RenameRule `json:"custom_rules"`
+	Template    string       `json:"template"`
+	Filters     FileFilter   `json:"filters"`
+	Version     int          `json:"version,omitempty"` // Bumped on every update
+	CreatedAt   int64        `json:"created_at,omitempty"`
+	UpdatedAt   int64        `json:"updated_at,omitempty"`
+	LastUsedAt  int64        `json:"last_used_at,omitempty"` // Unset if never executed
+}
+
+// PresetOverrides replaces parts of a preset for a single request.
+// Nil / empty fields keep the preset value.
+type PresetOverrides struct {
+	Mode        string        `json:"mode"`
+	QuickRules  *QuickOptions `json:"quick_rules"`
+	CustomRules []RenameRule  `json:"custom_rules"`
+	Template    *string       `json:"template"`
+	Filters     *FileFilter   `json:"filters"`
+}
+
+// Rule sets: portable preset bundles shared between installations
+const (
+	RuleSetKind          = "nas-renamer/ruleset"
+	RuleSetSchemaVersion = 1
+)
+
+type RuleSetDocument struct {
+	Kind          string   `json:"kind"`
+	SchemaVersion int      `json:"schema_version"`
+	ExportedAt    int64    `json:"exported_at"`
+	Presets       []Preset `json:"presets"`
+}
+
+type ImportWarning struct {
+	Preset   string `json:"preset"`
+	Rule     int    `json:"rule"` // Index in custom_rules, -1 if not rule specific
+	Message  string `json:"message"`
+	Blocking bool   `json:"blocking"` // Import refused unless forced
+}
+
+type ImportResponse struct {
+	SchemaVersion int             `json:"schema_version"`
+	DryRun        bool            `json:"dry_run"`
+	Imported      []Preset        `json:"imported"`
+	Warnings      []ImportWarning `json:"warnings"`
 }
 
 type DirListResponse struct {
@@ -99,3 +512,44 @@ type Session struct {
 	CreatedAt time.Time `json:"created_at"`
 	ExpiresAt time.Time `json:"expires_at"`
 }
+
+// Audit
+
+// AuditRecord is one entry of the audit log. Hash covers the record with an
+// empty Hash and chains it to the previous record through PrevHash.
+type AuditRecord struct {
+	Seq       int64           `json:"seq"` // 1 for the first record
+	Timestamp int64           `json:"timestamp"`
+	Action    string          `json:"action"` // See Audit* constants
+	Session   string          `json:"session,omitempty"`
+	IP        string          `json:"ip,omitempty"`
+	UserAgent string          `json:"user_agent,omitempty"`
+	Method    string          `json:"method,omitempty"`
+	Path      string          `json:"path,omitempty"`
+	Status    int             `json:"status"` // HTTP status of the response
+	Details   json.RawMessage `json:"details,omitempty"`
+	PrevHash  string          `json:"prev_hash"`
+	Hash      string          `json:"hash"`
+}
+
+// Audit actions
+const (
+	AuditLogin    = "login"
+	AuditConfig   = "config"
+	AuditPreset   = "preset"
+	AuditExecute  = "execute"
+	AuditUndo     = "undo"
+	AuditRedo     = "redo"
+	AuditRestore  = "restore"
+	AuditHistory  = "history"  // Pinning, pruning, export and deletion of history
+	AuditRecovery = "recovery" // Completing or rolling back a pending batch
+	AuditRepair   = "repair"   // A torn last line of the audit log was cut off on open
+)
+
+// AuditVerifyResponse is the result of checking the audit log chain.
+type AuditVerifyResponse struct {
+	Valid    bool   `json:"valid"`
+	Records  int64  `json:"records"`             // Records checked
+	BrokenAt int64  `json:"broken_at,omitempty"` // Seq of the first bad record
+	Error    string `json:"error,omitempty"`
+}
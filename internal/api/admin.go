@@ -0,0 +1,91 @@
+package api
+
+import (
+	"fmt"
+	"nas-renamer/design"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandlePinHistory pins or unpins a batch, pinned batches are never pruned.
+func (h *Handler) HandlePinHistory(c *gin.Context) {
+	var req design.PinRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.history.SetPinned(c.Param("id"), req.Pinned); err != nil {
+		c.JSON(historyErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
+
+// HandleExportHistory downloads history as JSON. The optional before query
+// parameter (Unix seconds) limits the export to batches older than that.
+func (h *Handler) HandleExportHistory(c *gin.Context) {
+	before, ok := bindBefore(c, false)
+	if !ok {
+		return
+	}
+	logs, err := h.history.Export(before)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	name := fmt.Sprintf("history-%s.json", time.Now().Format("20060102-150405"))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	c.JSON(http.StatusOK, logs)
+}
+
+// HandleDeleteHistory deletes unpinned batches older than the required before
+// query parameter (Unix seconds), with their undo and redo entries.
+func (h *Handler) HandleDeleteHistory(c *gin.Context) {
+	before, ok := bindBefore(c, true)
+	if !ok {
+		return
+	}
+	removed, err := h.history.DeleteBefore(before)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": len(removed)})
+}
+
+// HandlePruneHistory applies the retention policy now.
+func (h *Handler) HandlePruneHistory(c *gin.Context) {
+	settings, err := h.config.GetSettings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	removed, err := h.history.Prune(settings.HistoryRetention, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": len(removed)})
+}
+
+// bindBefore reads the before query parameter. It writes the error response
+// itself and returns false on bad input.
+func bindBefore(c *gin.Context, required bool) (time.Time, bool) {
+	var q struct {
+		Before int64 `form:"before"`
+	}
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return time.Time{}, false
+	}
+	if q.Before <= 0 {
+		if required {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "before (Unix seconds) is required"})
+			return time.Time{}, false
+		}
+		return time.Time{}, true
+	}
+	return time.Unix(q.Before, 0), true
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"nas-renamer/design"
+	"nas-renamer/internal/history"
+	"nas-renamer/internal/middleware"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditDetailsKey is the context key of the details a handler adds to its audit record.
+const auditDetailsKey = "audit.details"
+
+// setAuditDetail adds a detail to the audit record of the request.
+func setAuditDetail(c *gin.Context, key string, value any) {
+	details, _ := c.Get(auditDetailsKey)
+	m, ok := details.(map[string]any)
+	if !ok {
+		m = make(map[string]any)
+		c.Set(auditDetailsKey, m)
+	}
+	m[key] = value
+}
+
+// Audit records the request in the audit log once the handler is done,
+// with the response status and the details the handler set.
+func (h *Handler) Audit(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		rec := &design.AuditRecord{
+			Action:    action,
+			Session:   c.GetString(middleware.SessionKey),
+			IP:        c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			Method:    c.Request.Method,
+			Path:      c.Request.URL.Path,
+			Status:    c.Writer.Status(),
+		}
+		if details, ok := c.Get(auditDetailsKey); ok {
+			data, err := json.Marshal(details)
+			if err == nil {
+				rec.Details = data
+			}
+		}
+		if err := h.audit.Append(rec); err != nil {
+			log.Printf("Failed to write audit log for %s %s: %v", rec.Method, rec.Path, err)
+		}
+	}
+}
+
+// writeNotSaved reports renames that happened while their history entry was
+// lost. The result is included so the client knows what changed on disk.
+func writeNotSaved(c *gin.Context, resp any, err error) bool {
+	if !errors.Is(err, history.ErrHistoryNotSaved) {
+		return false
+	}
+	log.Printf("Failed to save history: %v", err)
+	setAuditDetail(c, "error", err.Error())
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "result": resp})
+	return true
+}
+
+// HandleGetAudit lists audit records, newest first. The optional offset and
+// limit query parameters select a page.
+func (h *Handler) HandleGetAudit(c *gin.Context) {
+	var page struct {
+		Offset int `form:"offset" binding:"min=0"`
+		Limit  int `form:"limit" binding:"min=0"`
+	}
+	if err := c.ShouldBindQuery(&page); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	records, err := h.audit.List(page.Offset, page.Limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, records)
+}
+
+// HandleVerifyAudit checks the hash chain of the audit log.
+func (h *Handler) HandleVerifyAudit(c *gin.Context) {
+	c.JSON(http.StatusOK, h.audit.Verify())
+}
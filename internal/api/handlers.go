@@ -1,8 +1,11 @@
 package api
 
 import (
+	"errors"
+	"log"
 	"nas-renamer/design"
 	"nas-renamer/internal/analyzer"
+	"nas-renamer/internal/audit"
 	"nas-renamer/internal/config"
 	"nas-renamer/internal/fs"
 	"nas-renamer/internal/history"
@@ -11,6 +14,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -21,14 +25,23 @@ type Handler struct {
 	renamer *renamer.Engine
 	history *history.Manager
 	config  *config.Manager
+	audit   *audit.Log
 	rootDir string
 }
 
-func NewHandler(rootDir string) (*Handler, error) {
-	hm, err := history.NewManager()
+// NewHandler serves files under rootDir and keeps history in historyDir
+// (empty for the default location, see history.NewManager). The audit log
+// is kept next to the history.
+func NewHandler(rootDir, historyDir string) (*Handler, error) {
+	hm, err := history.NewManager(historyDir)
 	if err != nil {
 		return nil, err
 	}
+	al, err := audit.Open(hm.Dir())
+	if err != nil {
+		hm.Close()
+		return nil, err
+	}
 	// Verify rootDir exists
 	if _, err := os.Stat(rootDir); os.IsNotExist(err) {
 		// Just warn or ignore
@@ -36,10 +49,25 @@ func NewHandler(rootDir string) (*Handler, error) {
 
 	cm := config.NewManager(rootDir)
 
+	// Batches cut short by a crash wait for the user to complete or roll them back
+	if pending, err := hm.Pending(); err != nil {
+		log.Printf("Failed to read the intent log: %v", err)
+	} else if len(pending) > 0 {
+		log.Printf("Found %d incomplete batches, see /api/recovery", len(pending))
+	}
+
+	// Apply the retention policy to history kept from earlier runs
+	if settings, err := cm.GetSettings(); err == nil {
+		if _, err := hm.Prune(settings.HistoryRetention, time.Now()); err != nil {
+			log.Printf("Failed to prune history: %v", err)
+		}
+	}
+
 	return &Handler{
-		renamer: renamer.NewEngine(),
+		renamer: renamer.NewEngineWithLexicon(cm),
 		history: hm,
 		config:  cm,
+		audit:   al,
 		rootDir: rootDir,
 	}, nil
 }
@@ -65,6 +93,7 @@ func (h *Handler) HandleLogin(c *gin.Context) {
 	}
 
 	token := middleware.Store.CreateSession()
+	c.Set(middleware.SessionKey, middleware.SessionID(token))
 	c.JSON(http.StatusOK, design.LoginResponse{
 		Token:     token,
 		ExpiresAt: time.Now().Add(24 * time.Hour),
@@ -115,10 +144,26 @@ func (h *Handler) HandlePreview(c *gin.Context) {
 		return
 	}
 
+	if status, err := h.resolveRequest(&req); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	q, ok := bindPreviewQuery(c)
+	if !ok {
+		return
+	}
+
 	// Inject ignored extensions
 	ignored, _ := h.config.GetIgnoredExtensions()
 
-	resp, err := h.renamer.ComputePreview(&req, ignored)
+	var resp *design.PreviewResponse
+	var err error
+	if q == (design.PreviewQuery{}) {
+		resp, err = h.renamer.ComputePreview(&req, ignored)
+	} else {
+		resp, err = h.renamer.ComputePreviewPage(&req, ignored, q)
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -134,33 +179,88 @@ func (h *Handler) HandleExecute(c *gin.Context) {
 		return
 	}
 
-	ignored, _ := h.config.GetIgnoredExtensions()
+	if status, err := h.resolveRequest(&req); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
 
-	// Updated signature: returns response, log, error
-	resp, log, err := h.renamer.ExecuteRename(&req, ignored)
+	ignored, _ := h.config.GetIgnoredExtensions()
+	settings, err := h.config.GetSettings()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Save History
-	if log != nil && len(log.Items) > 0 {
-		if err := h.history.SaveHistory(log); err != nil {
-			// Log error but generally success
-			// fmt.Printf("Failed to save history: %v\n", err)
+	setAuditDetail(c, "dir", req.DirPath)
+
+	// Updated signature: returns response, log, error
+	resp, entry, err := h.renamer.ExecuteRename(&req, renamer.ExecuteOptions{
+		IgnoredExts: ignored,
+		HashFiles:   settings.HashOnExecute,
+		Intents:     h.history,
+	})
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, fs.ErrDirectoryBusy) {
+			status = http.StatusConflict
 		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.PresetID != "" {
+		_ = h.config.TouchPreset(req.PresetID)
+	}
+
+	setAuditDetail(c, "batch_id", resp.BatchID)
+	setAuditDetail(c, "success_count", resp.SuccessCount)
+	setAuditDetail(c, "fail_count", resp.FailCount)
+	if req.Atomic {
+		setAuditDetail(c, "atomic", true)
+		setAuditDetail(c, "rolled_back", resp.RolledBack)
+	}
+
+	// Save History. Without it the renames cannot be undone, so say so.
+	// The batch then stays pending and can be completed or rolled back.
+	if err := h.history.CommitBatch(entry); writeNotSaved(c, resp, err) {
+		return
+	} else if err != nil {
+		log.Printf("Failed to remove the intent log of batch %s: %v", entry.ID, err)
+	}
+	if len(entry.Items) > 0 {
+		_, _ = h.history.Prune(settings.HistoryRetention, time.Now())
 	}
 
 	c.JSON(http.StatusOK, resp)
 }
 
+// HandleGetHistory lists entries, newest first, filtered and paged by the
+// query parameters of design.HistoryQuery. X-Total-Count holds the number of
+// matching entries, when counted, and X-Next-Cursor, when set, the cursor of
+// the next page.
 func (h *Handler) HandleGetHistory(c *gin.Context) {
-	logs, err := h.history.GetHistory()
+	var q design.HistoryQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if q.To != 0 && q.To < q.From {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must not be before from"})
+		return
+	}
+
+	res, err := h.history.Search(q)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(historyErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, logs)
+	if res.Total >= 0 {
+		c.Header("X-Total-Count", strconv.Itoa(res.Total))
+	}
+	if res.Next != "" {
+		c.Header("X-Next-Cursor", res.Next)
+	}
+	c.JSON(http.StatusOK, res.Logs)
 }
 
 func (h *Handler) HandleUndo(c *gin.Context) {
@@ -170,18 +270,196 @@ func (h *Handler) HandleUndo(c *gin.Context) {
 		return
 	}
 
-	resp, err := h.history.Undo(req.BatchID)
+	setAuditDetail(c, "batch_id", req.BatchID)
+	settings, err := h.config.GetSettings()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	resp, err := h.history.Undo(req.BatchID, history.UndoOptions{
+		Items:      req.Items,
+		Verify:     settings.UndoVerification,
+		OnConflict: req.OnConflict,
+	})
+	if writeNotSaved(c, resp, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(historyErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	setAuditDetail(c, "entry_id", resp.BatchID)
+	setAuditDetail(c, "success_count", resp.SuccessCount)
 
 	// Optimization Guide: Undo Bug Fix
 	c.JSON(http.StatusOK, gin.H{
 		"restored_count": resp.SuccessCount, // Mapping success_count to restored_count
 		"success":        true,
 		"error_count":    resp.FailCount,
+		"errors":         resp.Errors,
+		"batch_id":       resp.BatchID, // The undo's own history entry
+	})
+}
+
+// HandleRedo re-applies undone items of a batch.
+func (h *Handler) HandleRedo(c *gin.Context) {
+	var req design.UndoRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	setAuditDetail(c, "batch_id", req.BatchID)
+	settings, err := h.config.GetSettings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	resp, err := h.history.Redo(req.BatchID, history.UndoOptions{
+		Items:      req.Items,
+		Verify:     settings.UndoVerification,
+		OnConflict: req.OnConflict,
+	})
+	if writeNotSaved(c, resp, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(historyErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setAuditDetail(c, "entry_id", resp.BatchID)
+	setAuditDetail(c, "success_count", resp.SuccessCount)
+	c.JSON(http.StatusOK, resp)
+}
+
+// HandleRedoPreview reports per item whether undone items can be re-applied.
+func (h *Handler) HandleRedoPreview(c *gin.Context) {
+	var req design.UndoRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	settings, err := h.config.GetSettings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	resp, err := h.history.PreviewRedo(req.BatchID, history.UndoOptions{
+		Items:      req.Items,
+		Verify:     settings.UndoVerification,
+		OnConflict: req.OnConflict,
+	})
+	if err != nil {
+		c.JSON(historyErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// HandleGetLineage returns a batch with its undo and redo entries.
+func (h *Handler) HandleGetLineage(c *gin.Context) {
+	lineage, err := h.history.Lineage(c.Param("id"))
+	if err != nil {
+		c.JSON(historyErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, lineage)
+}
+
+// HandleFileLineage returns every name the file at the path query parameter
+// has had across batches.
+func (h *Handler) HandleFileLineage(c *gin.Context) {
+	path := c.Query("path")
+	if path == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "path is required"})
+		return
+	}
+	cleanPath, ok := h.checkPath(path)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: Path traversal detected"})
+		return
+	}
+	lineage, err := h.history.FileLineage(cleanPath)
+	if err != nil {
+		c.JSON(historyErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, lineage)
+}
+
+// HandleRestoreFile renames a file back to an earlier name from its lineage.
+func (h *Handler) HandleRestoreFile(c *gin.Context) {
+	var req design.FileRestoreRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	setAuditDetail(c, "path", req.Path)
+	setAuditDetail(c, "name", req.Name)
+	cleanPath, ok := h.checkPath(req.Path)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: Path traversal detected"})
+		return
+	}
+	settings, err := h.config.GetSettings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	resp, err := h.history.RestoreName(cleanPath, req.Name, req.OnConflict, settings.HashOnExecute)
+	if writeNotSaved(c, resp, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(historyErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setAuditDetail(c, "entry_id", resp.BatchID)
+	if resp.BatchID != "" {
+		_, _ = h.history.Prune(settings.HistoryRetention, time.Now())
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// HandleUndoPreview reports per item whether a batch can be undone.
+func (h *Handler) HandleUndoPreview(c *gin.Context) {
+	var req design.UndoRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	settings, err := h.config.GetSettings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	resp, err := h.history.PreviewUndo(req.BatchID, history.UndoOptions{
+		Items:      req.Items,
+		Verify:     settings.UndoVerification,
+		OnConflict: req.OnConflict,
 	})
+	if err != nil {
+		c.JSON(historyErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func historyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, history.ErrBatchNotFound), errors.Is(err, history.ErrFileNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, history.ErrItemNotFound), errors.Is(err, history.ErrNotUndoable),
+		errors.Is(err, history.ErrInvalidCursor), errors.Is(err, history.ErrNameNotInLineage):
+		return http.StatusBadRequest
+	case errors.Is(err, fs.ErrDirectoryBusy), errors.Is(err, history.ErrBatchActive):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
 }
 
 func (h *Handler) HandleGetConfig(c *gin.Context) {
@@ -206,6 +484,137 @@ func (h *Handler) HandleSetConfig(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "updated"})
 }
 
+func (h *Handler) HandleGetJunkTokens(c *gin.Context) {
+	tokens, err := h.config.GetJunkTokens()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (h *Handler) HandleSetJunkTokens(c *gin.Context) {
+	var tokens []design.JunkToken
+	if err := c.ShouldBindJSON(&tokens); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.config.SetJunkTokens(tokens); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
+
+func (h *Handler) HandleGetSceneTags(c *gin.Context) {
+	tags, err := h.config.GetSceneTags()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tags)
+}
+
+func (h *Handler) HandleSetSceneTags(c *gin.Context) {
+	var tags map[string][]string
+	if err := c.ShouldBindJSON(&tags); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.config.SetSceneTags(tags); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
+
+func (h *Handler) HandleGetSettings(c *gin.Context) {
+	settings, err := h.config.GetSettings()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+func (h *Handler) HandleSetSettings(c *gin.Context) {
+	var settings design.Settings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.config.SetSettings(settings); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, config.ErrInvalidSettings) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
+
+func (h *Handler) HandleGetAdTLDs(c *gin.Context) {
+	tlds, err := h.config.GetAdTLDs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tlds)
+}
+
+func (h *Handler) HandleSetAdTLDs(c *gin.Context) {
+	var tlds []string
+	if err := c.ShouldBindJSON(&tlds); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.config.SetAdTLDs(tlds); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "updated"})
+}
+
+// HandlePromoteJunk adds frequent string results to the junk dictionary.
+func (h *Handler) HandlePromoteJunk(c *gin.Context) {
+	var req design.PromoteJunkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens := req.Tokens
+	if len(tokens) == 0 {
+		dir := req.DirPath
+		if dir == "" {
+			dir = h.rootDir
+		}
+		cleanDir, ok := h.checkPath(dir)
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: Path traversal detected"})
+			return
+		}
+		var err error
+		tokens, err = analyzer.AnalyzeFrequentStrings(cleanDir)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	category := req.Category
+	if category == "" {
+		category = "ad"
+	}
+	added, err := h.config.AddJunkTokens(tokens, category)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"added": added})
+}
+
 func (h *Handler) HandleScanFrequent(c *gin.Context) {
 	dir := c.Query("dir")
 	if dir == "" {
@@ -213,10 +622,8 @@ func (h *Handler) HandleScanFrequent(c *gin.Context) {
 	}
 
 	// Security check for scan as well
-	cleanRootDir := filepath.Clean(h.rootDir)
-	cleanDir := filepath.Clean(dir)
-	rel, err := filepath.Rel(cleanRootDir, cleanDir)
-	if err != nil || strings.HasPrefix(rel, "..") || strings.HasPrefix(rel, string(filepath.Separator)+"..") {
+	cleanDir, ok := h.checkPath(dir)
+	if !ok {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: Path traversal detected"})
 		return
 	}
@@ -228,3 +635,14 @@ func (h *Handler) HandleScanFrequent(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, tokens)
 }
+
+// checkPath cleans dir and reports whether it is inside rootDir.
+func (h *Handler) checkPath(dir string) (string, bool) {
+	cleanRootDir := filepath.Clean(h.rootDir)
+	cleanDir := filepath.Clean(dir)
+	rel, err := filepath.Rel(cleanRootDir, cleanDir)
+	if err != nil || strings.HasPrefix(rel, "..") || strings.HasPrefix(rel, string(filepath.Separator)+"..") {
+		return cleanDir, false
+	}
+	return cleanDir, true
+}
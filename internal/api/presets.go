@@ -0,0 +1,187 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"nas-renamer/design"
+	"nas-renamer/internal/config"
+	"nas-renamer/internal/renamer"
+	"nas-renamer/internal/ruleset"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) HandleListPresets(c *gin.Context) {
+	presets, err := h.config.ListPresets()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if presets == nil {
+		presets = []design.Preset{}
+	}
+	c.JSON(http.StatusOK, presets)
+}
+
+func (h *Handler) HandleCreatePreset(c *gin.Context) {
+	var p design.Preset
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	created, err := h.config.CreatePreset(p)
+	if err != nil {
+		c.JSON(presetErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+func (h *Handler) HandleUpdatePreset(c *gin.Context) {
+	var p design.Preset
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	updated, err := h.config.UpdatePreset(c.Param("id"), p)
+	if err != nil {
+		c.JSON(presetErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+func (h *Handler) HandleDeletePreset(c *gin.Context) {
+	if err := h.config.DeletePreset(c.Param("id")); err != nil {
+		c.JSON(presetErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+func (h *Handler) HandleDuplicatePreset(c *gin.Context) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	// Body is optional
+	_ = c.ShouldBindJSON(&body)
+
+	dup, err := h.config.DuplicatePreset(c.Param("id"), body.Name)
+	if err != nil {
+		c.JSON(presetErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, dup)
+}
+
+// HandleExportPresets downloads presets as a rule set document.
+// Query: format=json|yaml (default json), ids=comma separated (default all).
+func (h *Handler) HandleExportPresets(c *gin.Context) {
+	format := c.DefaultQuery("format", ruleset.FormatJSON)
+
+	presets, err := h.config.ListPresets()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if ids := c.Query("ids"); ids != "" {
+		wanted := strings.Split(ids, ",")
+		presets = slices.DeleteFunc(presets, func(p design.Preset) bool {
+			return !slices.Contains(wanted, p.ID)
+		})
+	}
+
+	data, err := ruleset.Encode(ruleset.New(presets), format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	contentType := "application/json"
+	if format != ruleset.FormatJSON {
+		contentType = "application/yaml"
+		format = ruleset.FormatYAML
+	}
+	fileName := fmt.Sprintf("nas-renamer-rules-%s.%s", time.Now().Format("20060102"), format)
+	c.Header("Content-Disposition", "attachment; filename="+fileName)
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// HandleImportPresets reads a rule set document from the request body.
+// Query: format=json|yaml (default: detected), dry_run=true to only validate,
+// force=true to import despite blocking warnings (e.g. newer schema version).
+func (h *Handler) HandleImportPresets(c *gin.Context) {
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, 4<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	doc, warnings, err := ruleset.Decode(data, c.Query("format"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	valid, more := ruleset.Validate(doc)
+	warnings = append(warnings, more...)
+
+	resp := design.ImportResponse{
+		SchemaVersion: doc.SchemaVersion,
+		DryRun:        c.Query("dry_run") == "true",
+		Imported:      valid,
+		Warnings:      warnings,
+	}
+	if resp.DryRun {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	if ruleset.HasBlocking(warnings) && c.Query("force") != "true" {
+		resp.Imported = []design.Preset{}
+		c.JSON(http.StatusUnprocessableEntity, resp)
+		return
+	}
+
+	imported, err := h.config.ImportPresets(valid)
+	if err != nil {
+		c.JSON(presetErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	resp.Imported = imported
+	c.JSON(http.StatusOK, resp)
+}
+
+// resolveRequest loads the referenced preset into req and validates the mode
+// and the template.
+func (h *Handler) resolveRequest(req *design.RenameRequest) (int, error) {
+	if req.PresetID != "" {
+		p, err := h.config.GetPreset(req.PresetID)
+		if err != nil {
+			return presetErrorStatus(err), err
+		}
+		renamer.ApplyPreset(req, p)
+	}
+	if req.Mode == "" {
+		return http.StatusBadRequest, errors.New("mode or preset_id is required")
+	}
+	if err := renamer.ValidateTemplate(req.Template); err != nil {
+		return http.StatusBadRequest, err
+	}
+	return http.StatusOK, nil
+}
+
+func presetErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, config.ErrPresetNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, config.ErrPresetVersion):
+		return http.StatusConflict
+	case errors.Is(err, config.ErrInvalidPreset):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"nas-renamer/design"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleListPending lists batches cut short by a crash before their history was saved.
+func (h *Handler) HandleListPending(c *gin.Context) {
+	pending, err := h.history.Pending()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, pending)
+}
+
+// HandleCompletePending renames what is left of a pending batch and saves it to the history.
+func (h *Handler) HandleCompletePending(c *gin.Context) {
+	setAuditDetail(c, "batch_id", c.Param("id"))
+	resp, err := h.history.CompletePending(c.Param("id"))
+	h.writeRecovery(c, resp, err)
+}
+
+// HandleRollbackPending renames the files of a pending batch back to their original names.
+func (h *Handler) HandleRollbackPending(c *gin.Context) {
+	setAuditDetail(c, "batch_id", c.Param("id"))
+	resp, err := h.history.RollbackPending(c.Param("id"))
+	h.writeRecovery(c, resp, err)
+}
+
+func (h *Handler) writeRecovery(c *gin.Context, resp *design.ExecuteResponse, err error) {
+	if writeNotSaved(c, resp, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(historyErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	setAuditDetail(c, "success_count", resp.SuccessCount)
+	setAuditDetail(c, "fail_count", resp.FailCount)
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"nas-renamer/design"
+	"nas-renamer/internal/renamer"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamFlushEvery is how many items are written between flushes and
+// running summaries of a streamed preview.
+const streamFlushEvery = 256
+
+// bindPreviewQuery reads the pagination and filter parameters of a preview.
+// It writes the error response itself and returns false on bad input.
+func bindPreviewQuery(c *gin.Context) (design.PreviewQuery, bool) {
+	var q design.PreviewQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return q, false
+	}
+	if q.Offset < 0 || q.Limit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset and limit must not be negative"})
+		return q, false
+	}
+	switch q.Status {
+	case "", "ok", "conflict", "skipped", "error":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown status: " + q.Status})
+		return q, false
+	}
+	return q, true
+}
+
+// HandlePreviewStream computes a rename preview and streams it as NDJSON,
+// one design.PreviewEvent per line. The status and changed filters of
+// PreviewQuery apply to items; summaries always count the whole batch.
+func (h *Handler) HandlePreviewStream(c *gin.Context) {
+	var req design.RenameRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if status, err := h.resolveRequest(&req); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	q, ok := bindPreviewQuery(c)
+	if !ok {
+		return
+	}
+
+	ignored, _ := h.config.GetIgnoredExtensions()
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // Keep reverse proxies from buffering the stream
+	c.Status(http.StatusOK)
+
+	enc := json.NewEncoder(c.Writer)
+	ctx := c.Request.Context()
+	summary := &design.PreviewSummary{}
+	stats, err := h.renamer.PreviewEach(&req, ignored, func(item design.PreviewItem) error {
+		// Stop working for a client that went away
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		renamer.CountPreviewItem(summary, item)
+		if renamer.MatchesPreviewQuery(item, q) {
+			if err := enc.Encode(design.PreviewEvent{Item: &item}); err != nil {
+				return err
+			}
+		}
+		if summary.Total%streamFlushEvery == 0 {
+			running := *summary
+			if err := enc.Encode(design.PreviewEvent{Summary: &running}); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			// Headers are gone already, so report the error in the stream
+			_ = enc.Encode(design.PreviewEvent{Summary: summary, Error: err.Error()})
+		}
+		return
+	}
+	_ = enc.Encode(design.PreviewEvent{Summary: summary, Done: true, RuleStats: stats})
+	c.Writer.Flush()
+}
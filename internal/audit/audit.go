@@ -0,0 +1,281 @@
+// Package audit keeps an append-only, hash-chained log of what was done
+// through the API and by whom.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nas-renamer/design"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	logFile  = "audit.log"
+	headFile = "audit.head"
+
+	// Lines of the log can be long: details list batch IDs and paths
+	maxLine = 1 << 20
+)
+
+// ErrCorrupt is returned when a line of the log is not a record.
+var ErrCorrupt = errors.New("audit log is corrupt")
+
+// head is the seq and hash of the last record, kept in its own file so that
+// cutting records off the end of the log is detected as well.
+type head struct {
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// Log is the audit log in a directory. It is safe for concurrent use.
+type Log struct {
+	mu   sync.Mutex
+	dir  string
+	file *os.File
+	last head
+}
+
+// Open opens the audit log in dir, creating it if needed. A last line
+// without a newline, from a crash during a write, is cut off and the cut is
+// recorded in the log.
+func Open(dir string) (*Log, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{dir: dir, file: f}
+	cut, err := cutTornLine(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// Continue the chain from the last readable record. A corrupt or broken
+	// log is reported by Verify, appending goes on regardless.
+	err = scan(filepath.Join(dir, logFile), func(rec *design.AuditRecord) error {
+		l.last = head{Seq: rec.Seq, Hash: rec.Hash}
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrCorrupt) {
+		f.Close()
+		return nil, err
+	}
+	if cut > 0 {
+		details, _ := json.Marshal(map[string]int64{"truncated_bytes": cut})
+		if err := l.Append(&design.AuditRecord{Action: design.AuditRepair, Details: details}); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// cutTornLine truncates f after its last newline and returns the number of
+// bytes cut.
+func cutTornLine(f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	buf := make([]byte, 64<<10)
+	end := size
+	for end > 0 {
+		start := max(end-int64(len(buf)), 0)
+		chunk := buf[:end-start]
+		if _, err := f.ReadAt(chunk, start); err != nil {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			end = start + int64(i) + 1
+			break
+		}
+		end = start
+	}
+	if end == size {
+		return 0, nil
+	}
+	return size - end, f.Truncate(end)
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// Append chains rec to the last record and writes it. Seq, PrevHash and
+// Hash are set by Append, as is Timestamp if it is zero.
+func (l *Log) Append(rec *design.AuditRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if rec.Timestamp == 0 {
+		rec.Timestamp = time.Now().Unix()
+	}
+	rec.Seq = l.last.Seq + 1
+	rec.PrevHash = l.last.Hash
+	hash, err := hashRecord(rec)
+	if err != nil {
+		return err
+	}
+	rec.Hash = hash
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.last = head{Seq: rec.Seq, Hash: rec.Hash}
+	return l.writeHead()
+}
+
+func (l *Log) writeHead() error {
+	data, err := json.Marshal(l.last)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(l.dir, headFile)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// List returns up to limit records, newest first, skipping offset of them.
+// A limit of 0 means all.
+func (l *Log) List(offset, limit int) ([]design.AuditRecord, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var all []design.AuditRecord
+	err := scan(filepath.Join(l.dir, logFile), func(rec *design.AuditRecord) error {
+		all = append(all, *rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	records := []design.AuditRecord{}
+	for i := len(all) - 1 - offset; i >= 0; i-- {
+		if limit > 0 && len(records) == limit {
+			break
+		}
+		records = append(records, all[i])
+	}
+	return records, nil
+}
+
+// Verify checks every record against its hash and the chain, and the last
+// record against the head file. It finds edited, inserted, removed and
+// reordered records. Rewriting the whole log and the head file together is
+// not detected; copy the head hash elsewhere to guard against that.
+func (l *Log) Verify() *design.AuditVerifyResponse {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	resp := &design.AuditVerifyResponse{}
+	var prev head
+	errBroken := errors.New("chain broken")
+	err := scan(filepath.Join(l.dir, logFile), func(rec *design.AuditRecord) error {
+		resp.Records++
+		var problem string
+		switch hash, err := hashRecord(rec); {
+		case err != nil:
+			problem = err.Error()
+		case rec.Seq != prev.Seq+1:
+			problem = fmt.Sprintf("expected record %d, found %d", prev.Seq+1, rec.Seq)
+		case rec.PrevHash != prev.Hash:
+			problem = "previous hash does not match"
+		case rec.Hash != hash:
+			problem = "record was modified"
+		}
+		if problem != "" {
+			resp.BrokenAt = rec.Seq
+			resp.Error = fmt.Sprintf("record %d: %s", rec.Seq, problem)
+			return errBroken
+		}
+		prev = head{Seq: rec.Seq, Hash: rec.Hash}
+		return nil
+	})
+	if errors.Is(err, errBroken) {
+		return resp
+	}
+	if err != nil {
+		resp.BrokenAt = prev.Seq + 1
+		resp.Error = err.Error()
+		return resp
+	}
+
+	var stored head
+	data, err := os.ReadFile(filepath.Join(l.dir, headFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist) && prev.Seq == 0:
+	case err != nil:
+		resp.Error = fmt.Sprintf("cannot read head: %v", err)
+		return resp
+	case json.Unmarshal(data, &stored) != nil:
+		resp.Error = "head is corrupt"
+		return resp
+	case stored != prev:
+		resp.BrokenAt = prev.Seq + 1
+		resp.Error = fmt.Sprintf("log ends at record %d, head is at %d", prev.Seq, stored.Seq)
+		return resp
+	}
+	resp.Valid = true
+	return resp
+}
+
+// scan calls fn for each record of the log at path, oldest first.
+func scan(path string, fn func(*design.AuditRecord) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), maxLine)
+	for line := 1; scanner.Scan(); line++ {
+		var rec design.AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("%w: line %d: %v", ErrCorrupt, line, err)
+		}
+		if err := fn(&rec); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// hashRecord returns the SHA-256 of rec encoded with an empty Hash.
+func hashRecord(rec *design.AuditRecord) (string, error) {
+	r := *rec
+	r.Hash = ""
+	data, err := json.Marshal(&r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
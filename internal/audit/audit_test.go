@@ -0,0 +1,85 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"nas-renamer/design"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range []string{design.AuditLogin, design.AuditExecute} {
+		if err := l.Append(&design.AuditRecord{Action: action, IP: "10.0.0.1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	// Reopening continues the chain
+	if l, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.Append(&design.AuditRecord{Action: design.AuditUndo, IP: "10.0.0.1"})
+	if res := l.Verify(); !res.Valid || res.Records != 3 {
+		t.Fatalf("Expected a valid log of 3 records, got %+v", res)
+	}
+
+	path := filepath.Join(dir, logFile)
+	orig, _ := os.ReadFile(path)
+
+	edited := bytes.Replace(orig, []byte(`"action":"execute"`), []byte(`"action":"login"`), 1)
+	os.WriteFile(path, edited, 0600)
+	if res := l.Verify(); res.Valid || res.BrokenAt != 2 {
+		t.Errorf("Expected the edit of record 2 to be found, got %+v", res)
+	}
+
+	lines := bytes.SplitAfter(orig, []byte("\n"))
+	os.WriteFile(path, bytes.Join(append(lines[:1:1], lines[2:]...), nil), 0600)
+	if res := l.Verify(); res.Valid || res.BrokenAt != 3 {
+		t.Errorf("Expected the removal of record 2 to be found, got %+v", res)
+	}
+
+	os.WriteFile(path, bytes.Join(lines[:2], nil), 0600)
+	if res := l.Verify(); res.Valid {
+		t.Errorf("Expected the truncation to be found, got %+v", res)
+	}
+}
+
+func TestOpenCutsTornLine(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Append(&design.AuditRecord{Action: design.AuditLogin})
+	l.Close()
+
+	// A crash in the middle of writing the second record
+	torn := `{"seq":2,"action":"exec`
+	f, _ := os.OpenFile(filepath.Join(dir, logFile), os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString(torn)
+	f.Close()
+
+	if l, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if res := l.Verify(); !res.Valid || res.Records != 2 {
+		t.Fatalf("Expected a valid log of 2 records, got %+v", res)
+	}
+	records, err := l.List(0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := records[0]; rec.Action != design.AuditRepair || !bytes.Contains(rec.Details, []byte(fmt.Sprint(len(torn)))) {
+		t.Errorf("Expected the cut of %d bytes to be recorded, got %+v", len(torn), rec)
+	}
+}
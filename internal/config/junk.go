@@ -0,0 +1,100 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"nas-renamer/design"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const junkFile = "junk_tokens.json"
+
+// GetJunkTokens returns the junk token dictionary used by quick mode.
+func (m *Manager) GetJunkTokens() ([]design.JunkToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.loadJunkTokens()
+}
+
+// SetJunkTokens replaces the whole dictionary. Entries without an ID get one.
+func (m *Manager) SetJunkTokens(tokens []design.JunkToken) error {
+	for i := range tokens {
+		tokens[i].Pattern = strings.TrimSpace(tokens[i].Pattern)
+		if tokens[i].Pattern == "" {
+			return fmt.Errorf("entry %d: empty pattern", i+1)
+		}
+		if tokens[i].Regex {
+			if _, err := regexp.Compile(tokens[i].Pattern); err != nil {
+				return fmt.Errorf("entry %d: %w", i+1, err)
+			}
+		}
+		if tokens[i].ID == "" {
+			tokens[i].ID = uuid.New().String()
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.saveJunkTokens(tokens)
+}
+
+// AddJunkTokens appends literal entries, skipping ones already present.
+// It returns the entries that were added.
+func (m *Manager) AddJunkTokens(patterns []string, category string) ([]design.JunkToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tokens, err := m.loadJunkTokens()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(tokens))
+	for _, t := range tokens {
+		if !t.Regex {
+			seen[strings.ToLower(t.Pattern)] = true
+		}
+	}
+
+	var added []design.JunkToken
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if p == "" || seen[strings.ToLower(p)] {
+			continue
+		}
+		seen[strings.ToLower(p)] = true
+		added = append(added, design.JunkToken{
+			ID:       uuid.New().String(),
+			Pattern:  p,
+			Category: category,
+			Enabled:  true,
+		})
+	}
+	if len(added) == 0 {
+		return added, nil
+	}
+	return added, m.saveJunkTokens(append(tokens, added...))
+}
+
+func (m *Manager) loadJunkTokens() ([]design.JunkToken, error) {
+	data, err := os.ReadFile(filepath.Join(m.configDir, junkFile))
+	if os.IsNotExist(err) {
+		return []design.JunkToken{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tokens []design.JunkToken
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("corrupt %s: %w", junkFile, err)
+	}
+	return tokens, nil
+}
+
+func (m *Manager) saveJunkTokens(tokens []design.JunkToken) error {
+	return m.writeJSON(junkFile, tokens)
+}
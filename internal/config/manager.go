@@ -2,6 +2,7 @@ package config
 
 import (
 	"bufio"
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"strings"
@@ -71,3 +72,21 @@ func (m *Manager) SetIgnoredExtensions(exts []string) error {
 	}
 	return nil
 }
+
+// writeJSON stores v in the config dir. It writes to a temp file first so a
+// crash never leaves a half-written file behind.
+func (m *Manager) writeJSON(name string, v any) error {
+	if err := os.MkdirAll(m.configDir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(m.configDir, name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
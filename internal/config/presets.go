@@ -0,0 +1,265 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nas-renamer/design"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrPresetNotFound = errors.New("preset not found")
+	ErrPresetVersion  = errors.New("preset was modified by someone else, reload and try again")
+	ErrInvalidPreset  = errors.New("invalid preset")
+)
+
+const presetsFile = "presets.json"
+
+// ListPresets returns all presets, most recently used first.
+func (m *Manager) ListPresets() ([]design.Preset, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	presets, err := m.loadPresets()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(presets, func(i, j int) bool {
+		if presets[i].LastUsedAt != presets[j].LastUsedAt {
+			return presets[i].LastUsedAt > presets[j].LastUsedAt
+		}
+		return presets[i].Name < presets[j].Name
+	})
+	return presets, nil
+}
+
+func (m *Manager) GetPreset(id string) (*design.Preset, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	presets, err := m.loadPresets()
+	if err != nil {
+		return nil, err
+	}
+	i := findPreset(presets, id)
+	if i < 0 {
+		return nil, ErrPresetNotFound
+	}
+	return &presets[i], nil
+}
+
+// CreatePreset stores p as a new preset. ID, version and timestamps are assigned here.
+func (m *Manager) CreatePreset(p design.Preset) (*design.Preset, error) {
+	if err := validatePreset(&p); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	presets, err := m.loadPresets()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	p.ID = uuid.New().String()
+	p.Version = 1
+	p.CreatedAt = now
+	p.UpdatedAt = now
+	p.LastUsedAt = 0
+
+	presets = append(presets, p)
+	if err := m.savePresets(presets); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// UpdatePreset replaces the preset with the given ID.
+// If p.Version is set it must match the stored version.
+func (m *Manager) UpdatePreset(id string, p design.Preset) (*design.Preset, error) {
+	if err := validatePreset(&p); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	presets, err := m.loadPresets()
+	if err != nil {
+		return nil, err
+	}
+	i := findPreset(presets, id)
+	if i < 0 {
+		return nil, ErrPresetNotFound
+	}
+	old := presets[i]
+	if p.Version != 0 && p.Version != old.Version {
+		return nil, ErrPresetVersion
+	}
+
+	p.ID = old.ID
+	p.Version = old.Version + 1
+	p.CreatedAt = old.CreatedAt
+	p.UpdatedAt = time.Now().Unix()
+	p.LastUsedAt = old.LastUsedAt
+	presets[i] = p
+
+	if err := m.savePresets(presets); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (m *Manager) DeletePreset(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	presets, err := m.loadPresets()
+	if err != nil {
+		return err
+	}
+	i := findPreset(presets, id)
+	if i < 0 {
+		return ErrPresetNotFound
+	}
+	presets = append(presets[:i], presets[i+1:]...)
+	return m.savePresets(presets)
+}
+
+// DuplicatePreset copies a preset under a new name ("<name> (copy)" if empty).
+func (m *Manager) DuplicatePreset(id, name string) (*design.Preset, error) {
+	src, err := m.GetPreset(id)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = src.Name + " (copy)"
+	}
+	cp := *src
+	cp.Name = name
+	cp.Version = 0
+	cp.CustomRules = append([]design.RenameRule(nil), src.CustomRules...)
+	return m.CreatePreset(cp)
+}
+
+// TouchPreset records that a preset has just been used.
+func (m *Manager) TouchPreset(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	presets, err := m.loadPresets()
+	if err != nil {
+		return err
+	}
+	i := findPreset(presets, id)
+	if i < 0 {
+		return ErrPresetNotFound
+	}
+	presets[i].LastUsedAt = time.Now().Unix()
+	return m.savePresets(presets)
+}
+
+// ImportPresets stores presets as new entries in one write.
+// Names that already exist get an " (imported)" suffix.
+func (m *Manager) ImportPresets(in []design.Preset) ([]design.Preset, error) {
+	for i := range in {
+		if err := validatePreset(&in[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	presets, err := m.loadPresets()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(presets))
+	for _, p := range presets {
+		names[p.Name] = true
+	}
+
+	now := time.Now().Unix()
+	imported := make([]design.Preset, 0, len(in))
+	for _, p := range in {
+		for names[p.Name] {
+			p.Name += " (imported)"
+		}
+		names[p.Name] = true
+		p.ID = uuid.New().String()
+		p.Version = 1
+		p.CreatedAt = now
+		p.UpdatedAt = now
+		p.LastUsedAt = 0
+		imported = append(imported, p)
+	}
+
+	if err := m.savePresets(append(presets, imported...)); err != nil {
+		return nil, err
+	}
+	return imported, nil
+}
+
+// Internal helpers (callers hold m.mu)
+
+func (m *Manager) loadPresets() ([]design.Preset, error) {
+	data, err := os.ReadFile(filepath.Join(m.configDir, presetsFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var presets []design.Preset
+	if err := json.Unmarshal(data, &presets); err != nil {
+		return nil, fmt.Errorf("corrupt %s: %w", presetsFile, err)
+	}
+	return presets, nil
+}
+
+func (m *Manager) savePresets(presets []design.Preset) error {
+	if presets == nil {
+		presets = []design.Preset{}
+	}
+	return m.writeJSON(presetsFile, presets)
+}
+
+func findPreset(presets []design.Preset, id string) int {
+	for i := range presets {
+		if presets[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func validatePreset(p *design.Preset) error {
+	if p.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidPreset)
+	}
+	switch p.Mode {
+	case design.ModeQuick, design.ModeBasic, design.ModeAdvanced:
+	default:
+		return fmt.Errorf("%w: unknown mode %q", ErrInvalidPreset, p.Mode)
+	}
+	for _, m := range indexWidthRe.FindAllStringSubmatch(p.Template, -1) {
+		if width, err := strconv.Atoi(m[1]); err != nil || width > design.MaxIndexWidth {
+			return fmt.Errorf("%w: {index:%s} is wider than %d digits", ErrInvalidPreset, m[1], design.MaxIndexWidth)
+		}
+	}
+	return nil
+}
+
+// indexWidthRe finds the padded {index:N} placeholders of a template.
+var indexWidthRe = regexp.MustCompile(`\{index:(\d+)\}`)
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"nas-renamer/design"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const sceneTagsFile = "scene_tags.json"
+
+// DefaultSceneTags are used for every category missing from scene_tags.json.
+var DefaultSceneTags = map[string][]string{
+	design.SceneTagResolution: {
+		"480p", "576p", "720p", "1080p", "1080i", "1440p", "2160p", "4320p", "2K", "4K", "8K", "UHD", "FHD",
+	},
+	design.SceneTagSource: {
+		"WEB-DL", "WEBDL", "WEBRip", "WEB", "BluRay", "Blu-ray", "BDRip", "BRRip", "BDRemux", "Remux",
+		"HDTV", "HDRip", "DVDRip", "DVD", "HDCAM", "AMZN", "NF", "DSNP",
+	},
+	design.SceneTagCodec: {
+		"x264", "x265", "H264", "H.264", "H265", "H.265", "HEVC", "AVC", "AV1", "VP9", "XviD", "DivX",
+		"AAC", "AC3", "EAC3", "DDP5.1", "DD5.1", "DTS-HD", "DTS", "TrueHD", "Atmos", "FLAC", "10bit", "8bit",
+	},
+	design.SceneTagHDR: {
+		"HDR10+", "HDR10", "HDR", "DV", "DoVi", "HLG", "SDR",
+	},
+	design.SceneTagGroup: {
+		"RARBG", "YTS", "YIFY", "FGT", "CMCT", "FRDS", "CHD", "HDChina",
+	},
+	design.SceneTagChinese: {
+		"国语中字", "中英字幕", "中英双字", "中文字幕", "简繁字幕", "国英双语", "国粤双语",
+		"国语", "粤语", "中字", "双语", "高清", "超清", "蓝光", "无水印", "未删减",
+	},
+}
+
+// GetSceneTags returns the word list of every scene tag category.
+func (m *Manager) GetSceneTags() (map[string][]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sceneTags()
+}
+
+// sceneTags reads the stored lists over the defaults. The caller holds m.mu.
+func (m *Manager) sceneTags() (map[string][]string, error) {
+	tags := make(map[string][]string, len(DefaultSceneTags))
+	for k, v := range DefaultSceneTags {
+		tags[k] = slices.Clone(v)
+	}
+
+	data, err := os.ReadFile(filepath.Join(m.configDir, sceneTagsFile))
+	if os.IsNotExist(err) {
+		return tags, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored map[string][]string
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("corrupt %s: %w", sceneTagsFile, err)
+	}
+	for k, v := range stored {
+		tags[k] = v
+	}
+	return tags, nil
+}
+
+// SetSceneTags stores the given categories. Categories not included keep their current list.
+func (m *Manager) SetSceneTags(tags map[string][]string) error {
+	for k, words := range tags {
+		if !slices.Contains(design.SceneTagCategories, k) {
+			return fmt.Errorf("unknown scene tag category: %s", k)
+		}
+		cleaned := words[:0]
+		for _, w := range words {
+			if w = strings.TrimSpace(w); w != "" {
+				cleaned = append(cleaned, w)
+			}
+		}
+		tags[k] = cleaned
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, err := m.sceneTags()
+	if err != nil {
+		return err
+	}
+	for k, v := range tags {
+		current[k] = v
+	}
+	return m.writeJSON(sceneTagsFile, current)
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nas-renamer/design"
+	"os"
+	"path/filepath"
+)
+
+const settingsFile = "settings.json"
+
+// ErrInvalidSettings is returned by SetSettings for unknown option values.
+var ErrInvalidSettings = errors.New("invalid settings")
+
+// DefaultSettings apply to every option missing from settings.json.
+var DefaultSettings = design.Settings{
+	UndoVerification: design.VerifyStrict,
+}
+
+// GetSettings returns the global settings.
+func (m *Manager) GetSettings() (design.Settings, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	settings := DefaultSettings
+	data, err := os.ReadFile(filepath.Join(m.configDir, settingsFile))
+	if os.IsNotExist(err) {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return DefaultSettings, fmt.Errorf("corrupt %s: %w", settingsFile, err)
+	}
+	if settings.UndoVerification == "" {
+		settings.UndoVerification = DefaultSettings.UndoVerification
+	}
+	return settings, nil
+}
+
+// SetSettings validates and stores the global settings.
+func (m *Manager) SetSettings(s design.Settings) error {
+	switch s.UndoVerification {
+	case "":
+		s.UndoVerification = DefaultSettings.UndoVerification
+	case design.VerifyStrict, design.VerifyWarn, design.VerifyIgnore:
+	default:
+		return fmt.Errorf("%w: unknown undo verification policy %q", ErrInvalidSettings, s.UndoVerification)
+	}
+
+	r := s.HistoryRetention
+	if r.MaxAgeDays < 0 || r.MaxCount < 0 || r.MaxSizeMB < 0 {
+		return fmt.Errorf("%w: retention limits must not be negative", ErrInvalidSettings)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.writeJSON(settingsFile, s)
+}
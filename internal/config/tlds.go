@@ -0,0 +1,69 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const adTLDsFile = "ad_tlds.txt"
+
+// DefaultAdTLDs are the top level domains RemoveURL treats as ad domains.
+// TLDs that are common words in titles ("in", "us", "me", "live", ...) are
+// left out; add them to ad_tlds.txt if a library needs them.
+var DefaultAdTLDs = []string{
+	"com", "net", "org", "cn", "com.cn", "net.cn", "cc", "io", "xyz", "top", "vip",
+	"info", "biz", "online", "ws", "tk", "pw", "icu", "hk", "tw",
+}
+
+// GetAdTLDs returns the configured TLD list, one entry per line in ad_tlds.txt.
+func (m *Manager) GetAdTLDs() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tlds, err := m.readList(adTLDsFile)
+	if os.IsNotExist(err) {
+		return append([]string(nil), DefaultAdTLDs...), nil
+	}
+	return tlds, err
+}
+
+func (m *Manager) SetAdTLDs(tlds []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cleaned := make([]string, 0, len(tlds))
+	for _, t := range tlds {
+		t = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(t), "."))
+		if t != "" {
+			cleaned = append(cleaned, t)
+		}
+	}
+	return m.writeList(adTLDsFile, cleaned)
+}
+
+// readList reads a file with one entry per line, skipping blank lines.
+func (m *Manager) readList(name string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(m.configDir, name))
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+func (m *Manager) writeList(name string, lines []string) error {
+	if err := os.MkdirAll(m.configDir, 0755); err != nil {
+		return err
+	}
+	var sb strings.Builder
+	for _, line := range lines {
+		sb.WriteString(line + "\n")
+	}
+	return os.WriteFile(filepath.Join(m.configDir, name), []byte(sb.String()), 0644)
+}
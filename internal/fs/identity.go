@@ -0,0 +1,74 @@
+package fs
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+)
+
+// hashChunk is how much of the head and of the tail of a file PartialHash reads.
+const hashChunk = 64 << 10
+
+// Identity is what is recorded about a file to recognize it later.
+// Zero values mean unknown.
+type Identity struct {
+	Size    int64
+	ModTime int64 // Unix nanoseconds
+	Dev     uint64
+	Inode   uint64
+	Hash    string // See PartialHash, only when requested
+}
+
+// Identify stats path and, if withHash is set, hashes part of its content.
+func Identify(path string, withHash bool) (Identity, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Identity{}, err
+	}
+	id := Identity{
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+	}
+	id.Dev, id.Inode = fileID(path, info)
+	if withHash {
+		if id.Hash, err = PartialHash(path, info.Size()); err != nil {
+			return id, err
+		}
+	}
+	return id, nil
+}
+
+// PartialHash returns a SHA-256 of the size, the first and the last 64 KiB of a
+// file. Reading whole videos on a NAS is too slow, and a replaced release
+// almost always differs in its header or tail.
+func PartialHash(path string, size int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	var sizeBuf [8]byte
+	binary.LittleEndian.PutUint64(sizeBuf[:], uint64(size))
+	h.Write(sizeBuf[:])
+
+	if _, err := io.CopyN(h, f, hashChunk); err != nil && err != io.EOF {
+		return "", err
+	}
+	if size > 2*hashChunk {
+		if _, err := f.Seek(-hashChunk, io.SeekEnd); err != nil {
+			return "", err
+		}
+		if _, err := io.CopyN(h, f, hashChunk); err != nil && err != io.EOF {
+			return "", err
+		}
+	} else if size > hashChunk {
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package fs
+
+import "os"
+
+// fileID is not available on this platform.
+func fileID(_ string, _ os.FileInfo) (dev, ino uint64) {
+	return 0, 0
+}
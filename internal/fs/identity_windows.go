@@ -0,0 +1,23 @@
+//go:build windows
+
+package fs
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID returns the volume serial number and file index of a file.
+func fileID(path string, _ os.FileInfo) (dev, ino uint64) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+
+	var d syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(syscall.Handle(f.Fd()), &d); err != nil {
+		return 0, 0
+	}
+	return uint64(d.VolumeSerialNumber), uint64(d.FileIndexHigh)<<32 | uint64(d.FileIndexLow)
+}
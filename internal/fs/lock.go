@@ -0,0 +1,41 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+)
+
+// ErrDirectoryBusy is returned by LockDir while another batch runs on the directory.
+var ErrDirectoryBusy = errors.New("directory busy: another batch is running on it")
+
+var dirLocks = struct {
+	mu   sync.Mutex
+	held map[string]bool
+}{held: make(map[string]bool)}
+
+// LockDir claims dir for a batch of renames within this process. It does not
+// wait: a second caller gets ErrDirectoryBusy until the returned unlock runs.
+func LockDir(dir string) (unlock func(), err error) {
+	key, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	dirLocks.mu.Lock()
+	defer dirLocks.mu.Unlock()
+	if dirLocks.held[key] {
+		return nil, fmt.Errorf("%w: %s", ErrDirectoryBusy, dir)
+	}
+	dirLocks.held[key] = true
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			dirLocks.mu.Lock()
+			delete(dirLocks.held, key)
+			dirLocks.mu.Unlock()
+		})
+	}, nil
+}
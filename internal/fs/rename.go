@@ -0,0 +1,142 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"nas-renamer/design"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrTargetExists is returned, wrapped in an *os.LinkError, when a rename
+// would replace an existing file.
+var ErrTargetExists = errors.New("target already exists")
+
+// errUnsupported is returned by renameNoReplace when the platform or the file
+// system has no atomic no-replace rename.
+var errUnsupported = errors.New("no-replace rename not supported")
+
+// maxSuffix bounds the names tried by the suffix conflict strategy.
+const maxSuffix = 999
+
+// RenameNoReplace renames oldpath to newpath but fails with ErrTargetExists
+// instead of replacing a file at newpath. It is atomic where the platform
+// allows (renameat2 on Linux, renamex_np on macOS, MoveFileEx on Windows) and
+// falls back to a hard link, then to an exclusively created placeholder.
+func RenameNoReplace(oldpath, newpath string) error {
+	// A case-only rename on a case-insensitive file system finds the file itself at newpath
+	if sameFile(oldpath, newpath) {
+		return os.Rename(oldpath, newpath)
+	}
+
+	err := renameNoReplace(oldpath, newpath)
+	if !errors.Is(err, errUnsupported) {
+		return err
+	}
+
+	// Creating a link fails atomically if newpath exists
+	err = os.Link(oldpath, newpath)
+	if err == nil {
+		if err := os.Remove(oldpath); err != nil {
+			_ = os.Remove(newpath)
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+		}
+		return nil
+	}
+	if errors.Is(err, os.ErrExist) {
+		return existsError(oldpath, newpath)
+	}
+
+	// No hard links either (FAT, some network shares)
+	return renamePlaceholder(oldpath, newpath)
+}
+
+// renamePlaceholder claims newpath with an empty file, which fails if
+// newpath exists, and then renames oldpath over it. A file created at
+// newpath in between is never replaced, only the placeholder is.
+func renamePlaceholder(oldpath, newpath string) error {
+	f, err := os.OpenFile(newpath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return existsError(oldpath, newpath)
+		}
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	f.Close()
+	if err := os.Rename(oldpath, newpath); err != nil {
+		_ = os.Remove(newpath)
+		return err
+	}
+	return nil
+}
+
+// RenameResolving renames oldpath to name in dir without replacing files.
+// With design.ConflictSuffix a taken name is replaced by the first free
+// "name (n).ext"; otherwise the rename fails with ErrTargetExists.
+// It returns the name the file got.
+func RenameResolving(oldpath, dir, name, strategy string) (string, error) {
+	err := RenameNoReplace(oldpath, filepath.Join(dir, name))
+	if err == nil || strategy != design.ConflictSuffix || !errors.Is(err, ErrTargetExists) {
+		return name, err
+	}
+	for n := 1; n <= maxSuffix; n++ {
+		candidate := SuffixedName(name, n)
+		err = RenameNoReplace(oldpath, filepath.Join(dir, candidate))
+		if !errors.Is(err, ErrTargetExists) {
+			return candidate, err
+		}
+	}
+	return name, err
+}
+
+// TargetName returns the name RenameResolving would give oldpath right now:
+// name, or with design.ConflictSuffix the first free "name (n).ext" if
+// another file has name.
+func TargetName(oldpath, dir, name, strategy string) (string, error) {
+	if strategy != design.ConflictSuffix || sameFile(oldpath, filepath.Join(dir, name)) {
+		return name, nil
+	}
+	return FreeName(dir, name, nil)
+}
+
+// FreeName returns name if it is neither in dir nor reserved, else the first
+// free "name (n).ext". It fails after maxSuffix attempts.
+func FreeName(dir, name string, reserved func(string) bool) (string, error) {
+	for n := 0; n <= maxSuffix; n++ {
+		candidate := name
+		if n > 0 {
+			candidate = SuffixedName(name, n)
+		}
+		if reserved != nil && reserved(candidate) {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(dir, candidate)); os.IsNotExist(err) {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no free name for %s", name)
+}
+
+// SuffixedName returns "base (n).ext" for name "base.ext".
+func SuffixedName(name string, n int) string {
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext)
+}
+
+func existsError(oldpath, newpath string) error {
+	return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: ErrTargetExists}
+}
+
+// sameFile reports whether both paths name the same existing file.
+func sameFile(a, b string) bool {
+	ai, err := os.Lstat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Lstat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ai, bi)
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !windows
+
+package fs
+
+func renameNoReplace(_, _ string) error {
+	return errUnsupported
+}
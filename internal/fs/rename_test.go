@@ -0,0 +1,103 @@
+package fs
+
+import (
+	"errors"
+	"nas-renamer/design"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// expectContent checks that the file name in dir still has its own content.
+func expectContent(t *testing.T, dir, name, content string) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil || string(data) != content {
+		t.Errorf("Expected %s to hold %q, got %q %v", name, content, data, err)
+	}
+}
+
+func TestRenameNoReplace(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, "a.txt", "b.txt")
+
+	err := RenameNoReplace(filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt"))
+	if !errors.Is(err, ErrTargetExists) {
+		t.Fatalf("Expected ErrTargetExists, got %v", err)
+	}
+	expectContent(t, dir, "a.txt", "a.txt")
+	expectContent(t, dir, "b.txt", "b.txt")
+
+	// A case-only rename is not a conflict with the file itself
+	if err := RenameNoReplace(filepath.Join(dir, "a.txt"), filepath.Join(dir, "A.txt")); err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, dir, "A.txt", "a.txt")
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("Expected A.txt and b.txt only, got %v", entries)
+	}
+}
+
+func TestRenamePlaceholder(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, "a.txt", "b.txt")
+
+	err := renamePlaceholder(filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt"))
+	if !errors.Is(err, ErrTargetExists) {
+		t.Fatalf("Expected ErrTargetExists, got %v", err)
+	}
+	expectContent(t, dir, "b.txt", "b.txt")
+
+	if err := renamePlaceholder(filepath.Join(dir, "a.txt"), filepath.Join(dir, "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+	expectContent(t, dir, "c.txt", "a.txt")
+	if _, err := os.Lstat(filepath.Join(dir, "a.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a.txt gone, got %v", err)
+	}
+
+	// A failed rename leaves no placeholder behind
+	if err := renamePlaceholder(filepath.Join(dir, "missing.txt"), filepath.Join(dir, "d.txt")); err == nil {
+		t.Fatal("Expected an error for a missing source")
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "d.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected no placeholder at d.txt, got %v", err)
+	}
+}
+
+func TestRenameResolving(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, "a.txt", "b.txt", "taken.txt", "taken (1).txt")
+
+	// Skip leaves both files alone
+	name, err := RenameResolving(filepath.Join(dir, "a.txt"), dir, "taken.txt", design.ConflictSkip)
+	if !errors.Is(err, ErrTargetExists) || name != "taken.txt" {
+		t.Fatalf("Expected ErrTargetExists, got %q %v", name, err)
+	}
+	expectContent(t, dir, "a.txt", "a.txt")
+	expectContent(t, dir, "taken.txt", "taken.txt")
+
+	// Suffix takes the first free name
+	name, err = RenameResolving(filepath.Join(dir, "a.txt"), dir, "taken.txt", design.ConflictSuffix)
+	if err != nil || name != "taken (2).txt" {
+		t.Fatalf("Expected taken (2).txt, got %q %v", name, err)
+	}
+	expectContent(t, dir, "taken (2).txt", "a.txt")
+	expectContent(t, dir, "taken (1).txt", "taken (1).txt")
+
+	// A free name is used as is
+	name, err = RenameResolving(filepath.Join(dir, "b.txt"), dir, "free.txt", design.ConflictSuffix)
+	if err != nil || name != "free.txt" {
+		t.Fatalf("Expected free.txt, got %q %v", name, err)
+	}
+}
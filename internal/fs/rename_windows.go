@@ -0,0 +1,29 @@
+package fs
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// renameNoReplace uses MoveFileEx without MOVEFILE_REPLACE_EXISTING, which
+// fails if newpath exists. os.Rename sets that flag.
+func renameNoReplace(oldpath, newpath string) error {
+	from, err := windows.UTF16PtrFromString(oldpath)
+	if err != nil {
+		return err
+	}
+	to, err := windows.UTF16PtrFromString(newpath)
+	if err != nil {
+		return err
+	}
+	err = windows.MoveFileEx(from, to, 0)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, windows.ERROR_ALREADY_EXISTS), errors.Is(err, windows.ERROR_FILE_EXISTS):
+		return existsError(oldpath, newpath)
+	}
+	return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+}
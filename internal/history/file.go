@@ -0,0 +1,167 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"nas-renamer/design"
+	"nas-renamer/internal/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrFileNotFound is returned for a lineage or restore of a path that does not exist.
+	ErrFileNotFound = errors.New("file not found")
+	// ErrNameNotInLineage is returned when a restore asks for a name the file never had.
+	ErrNameNotInLineage = errors.New("name is not in the lineage of the file")
+)
+
+// FileLineage returns every rename of the file at path, oldest first,
+// including undo and redo. See design.FileLineage for how files are matched.
+func (m *Manager) FileLineage(path string) (*design.FileLineage, error) {
+	path = filepath.Clean(path)
+	id, err := fs.Identify(path, false)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrFileNotFound, path)
+		}
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	renames, err := m.fileRenames(path, id)
+	if err != nil {
+		return nil, err
+	}
+	return &design.FileLineage{
+		Path:    path,
+		Size:    id.Size,
+		Inode:   id.Inode,
+		Renames: renames,
+	}, nil
+}
+
+// fileRenames walks the history from the newest entry back, following the
+// file through each rename.
+func (m *Manager) fileRenames(path string, id fs.Identity) ([]design.FileRename, error) {
+	renames := []design.FileRename{}
+	current := path
+	order := m.journal.order
+	for i := len(order) - 1; i >= 0; i-- {
+		log, err := m.journal.read(order[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range log.Items {
+			from, to := filepath.Join(log.BasePath, item.OriginalName), filepath.Join(log.BasePath, item.NewName)
+			if !sameFile(item, id, to, current) {
+				continue
+			}
+			renames = append(renames, design.FileRename{
+				BatchID:   log.ID,
+				Kind:      kindOf(log),
+				Timestamp: log.Timestamp,
+				Dir:       log.BasePath,
+				From:      item.OriginalName,
+				To:        item.NewName,
+			})
+			current = from
+			break // An entry renames a file at most once
+		}
+	}
+	for i, j := 0, len(renames)-1; i < j; i, j = i+1, j-1 {
+		renames[i], renames[j] = renames[j], renames[i]
+	}
+	return renames, nil
+}
+
+// sameFile reports whether item renamed the file with identity id to path
+// to. Without a recorded inode it falls back to the file's name at that
+// point, current.
+func sameFile(item design.HistoryItem, id fs.Identity, to, current string) bool {
+	if item.Inode == 0 || id.Inode == 0 {
+		return to == current
+	}
+	if item.Dev != 0 && id.Dev != 0 && item.Dev != id.Dev {
+		return false
+	}
+	return item.Inode == id.Inode && item.Size == id.Size
+}
+
+// RestoreName renames the file at path to name, which must be one of the
+// names in its lineage. The file stays in its current directory. The rename
+// is saved as a history entry of its own, so it can be undone.
+func (m *Manager) RestoreName(path, name, onConflict string, hashFiles bool) (*design.ExecuteResponse, error) {
+	path = filepath.Clean(path)
+	dir := filepath.Dir(path)
+	unlock, err := fs.LockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	id, err := fs.Identify(path, hashFiles)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrFileNotFound, path)
+		}
+		return nil, err
+	}
+
+	m.mu.Lock()
+	renames, err := m.fileRenames(path, id)
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	known := false
+	for _, r := range renames {
+		if r.From == name || r.To == name {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return nil, fmt.Errorf("%w: %s", ErrNameNotInLineage, name)
+	}
+
+	resp := &design.ExecuteResponse{Errors: []string{}}
+	oldName := filepath.Base(path)
+	if name == oldName {
+		return resp, nil
+	}
+	newName, err := fs.RenameResolving(path, dir, name, onConflict)
+	if err != nil {
+		resp.FailCount = 1
+		resp.Errors = append(resp.Errors, fmt.Sprintf("Failed to rename %s to %s: %v", oldName, name, err))
+		return resp, nil
+	}
+	resp.SuccessCount = 1
+
+	entry := &design.HistoryLog{
+		ID:        uuid.New().String(),
+		Timestamp: time.Now().Unix(),
+		BasePath:  dir,
+		Mode:      design.ModeRestore,
+		Items: []design.HistoryItem{{
+			OriginalName: oldName,
+			NewName:      newName,
+			Size:         id.Size,
+			State:        design.ItemApplied,
+			ModTime:      id.ModTime,
+			Dev:          id.Dev,
+			Inode:        id.Inode,
+			Hash:         id.Hash,
+		}},
+	}
+	resp.BatchID = entry.ID
+	if err := m.SaveHistory(entry); err != nil {
+		return resp, fmt.Errorf("%w: %v", ErrHistoryNotSaved, err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,368 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nas-renamer/design"
+	"nas-renamer/internal/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+const (
+	intentDir = "intents"
+	intentExt = ".wal"
+)
+
+// ErrBatchActive is returned for recovery of a batch that is still running.
+var ErrBatchActive = errors.New("batch is still running")
+
+// Intent record operations
+const (
+	intentBegin  = "begin"
+	intentIntend = "intend"
+	intentDone   = "done"
+)
+
+// intentRecord is one line of an intent file. A batch starts with a begin
+// record listing every planned rename. Each rename is preceded by an intend
+// record, synced to disk, and followed by a done record.
+type intentRecord struct {
+	Op    string               `json:"op"`
+	Log   *design.HistoryLog   `json:"log,omitempty"`   // Begin: the batch without items
+	Items []design.HistoryItem `json:"items,omitempty"` // Begin: planned renames
+	Index int                  `json:"index"`           // Intend and done: planned item
+	Item  *design.HistoryItem  `json:"item,omitempty"`  // Intend: the file before the rename and its target
+	Name  string               `json:"name,omitempty"`  // Done: the name the file got
+}
+
+// intents keeps one file per running batch in the intents dir of the history.
+type intents struct {
+	mu     sync.Mutex
+	active map[string]*os.File // Open intent files by batch ID
+}
+
+func (m *Manager) intentPath(batchID string) string {
+	return filepath.Join(m.baseDir, intentDir, batchID+intentExt)
+}
+
+// Begin starts the intent file of a batch with its planned renames. It is
+// part of renamer.IntentLog.
+func (m *Manager) Begin(log *design.HistoryLog, planned []design.HistoryItem) error {
+	if err := os.MkdirAll(filepath.Join(m.baseDir, intentDir), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(m.intentPath(log.ID), os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	header := *log
+	header.Items = nil
+	if err := writeIntent(f, &intentRecord{Op: intentBegin, Log: &header, Items: planned}, true); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	m.intents.mu.Lock()
+	defer m.intents.mu.Unlock()
+	m.intents.active[log.ID] = f
+	return nil
+}
+
+// Intend records that planned item index is about to be renamed. It returns
+// once the record is on disk.
+func (m *Manager) Intend(batchID string, index int, item design.HistoryItem) error {
+	return m.writeActive(batchID, &intentRecord{Op: intentIntend, Index: index, Item: &item}, true)
+}
+
+// Done records that planned item index was renamed to name. It is not
+// synced: recovery finds a lost done record by looking at the files.
+func (m *Manager) Done(batchID string, index int, name string) error {
+	return m.writeActive(batchID, &intentRecord{Op: intentDone, Index: index, Name: name}, false)
+}
+
+func (m *Manager) writeActive(batchID string, rec *intentRecord, sync bool) error {
+	m.intents.mu.Lock()
+	defer m.intents.mu.Unlock()
+
+	f := m.intents.active[batchID]
+	if f == nil {
+		return fmt.Errorf("%w: %s", ErrBatchNotFound, batchID)
+	}
+	return writeIntent(f, rec, sync)
+}
+
+func writeIntent(f *os.File, rec *intentRecord, sync bool) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if sync {
+		return f.Sync()
+	}
+	return nil
+}
+
+// CommitBatch saves the history of a batch that ran with an intent log and
+// removes its intent file. If the history cannot be saved the intent file is
+// kept, so the batch shows up as pending.
+func (m *Manager) CommitBatch(log *design.HistoryLog) error {
+	m.intents.mu.Lock()
+	f := m.intents.active[log.ID]
+	delete(m.intents.active, log.ID)
+	m.intents.mu.Unlock()
+	if f != nil {
+		f.Close()
+	}
+
+	if len(log.Items) > 0 {
+		if err := m.SaveHistory(log); err != nil {
+			return fmt.Errorf("%w: %v", ErrHistoryNotSaved, err)
+		}
+	}
+	if err := os.Remove(m.intentPath(log.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// pendingBatch is a pending batch as read from its intent file.
+type pendingBatch struct {
+	log      *design.HistoryLog
+	planned  []design.HistoryItem
+	intended map[int]design.HistoryItem
+	done     map[int]string
+}
+
+// Pending lists the batches that have an intent file but are not running,
+// oldest first.
+func (m *Manager) Pending() ([]design.PendingBatch, error) {
+	paths, err := filepath.Glob(filepath.Join(m.baseDir, intentDir, "*"+intentExt))
+	if err != nil {
+		return nil, err
+	}
+	batches := []design.PendingBatch{}
+	for _, path := range paths {
+		id := strings.TrimSuffix(filepath.Base(path), intentExt)
+		if m.isActive(id) {
+			continue
+		}
+		p, err := readIntent(path)
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, p.summary())
+	}
+	sort.Slice(batches, func(i, j int) bool { return batches[i].Timestamp < batches[j].Timestamp })
+	return batches, nil
+}
+
+func (m *Manager) isActive(batchID string) bool {
+	m.intents.mu.Lock()
+	defer m.intents.mu.Unlock()
+	return m.intents.active[batchID] != nil
+}
+
+// CompletePending renames the items of a pending batch that were not renamed
+// yet and saves the batch to the history.
+func (m *Manager) CompletePending(batchID string) (*design.ExecuteResponse, error) {
+	return m.recover(batchID, false)
+}
+
+// RollbackPending renames the items of a pending batch that were renamed back
+// to their original names. Nothing is saved to the history. The intent file
+// is kept until every item is back, so a failed rollback can be retried.
+func (m *Manager) RollbackPending(batchID string) (*design.ExecuteResponse, error) {
+	return m.recover(batchID, true)
+}
+
+func (m *Manager) recover(batchID string, rollback bool) (*design.ExecuteResponse, error) {
+	if m.isActive(batchID) {
+		return nil, fmt.Errorf("%w: %s", ErrBatchActive, batchID)
+	}
+	path := m.intentPath(batchID)
+	if _, err := uuid.Parse(batchID); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrBatchNotFound, batchID)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrBatchNotFound, batchID)
+	}
+	p, err := readIntent(path)
+	if err != nil {
+		return nil, err
+	}
+	base := p.log.BasePath
+	unlock, err := fs.LockDir(base)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	resp := &design.ExecuteResponse{BatchID: batchID, Errors: []string{}}
+	fail := func(format string, args ...any) {
+		resp.FailCount++
+		resp.Errors = append(resp.Errors, fmt.Sprintf(format, args...))
+	}
+	log := *p.log
+	log.Items = nil
+	for i, planned := range p.planned {
+		state, item := p.state(i)
+		switch {
+		case state == design.PendingUnknown:
+			fail("Cannot find %s or %s", planned.OriginalName, planned.NewName)
+		case rollback && state == design.PendingRenamed:
+			err := fs.RenameNoReplace(filepath.Join(base, item.NewName), filepath.Join(base, item.OriginalName))
+			if err != nil {
+				fail("Failed to rename %s back to %s: %v", item.NewName, item.OriginalName, err)
+				continue
+			}
+			resp.SuccessCount++
+		case !rollback && state == design.PendingRenamed:
+			log.Items = append(log.Items, item)
+		case !rollback && state == design.PendingNotRenamed:
+			oldPath := filepath.Join(base, planned.OriginalName)
+			id, _ := fs.Identify(oldPath, false)
+			name, err := fs.RenameResolving(oldPath, base, planned.NewName, design.ConflictSkip)
+			if err != nil {
+				fail("Failed to rename %s: %v", planned.OriginalName, err)
+				continue
+			}
+			resp.SuccessCount++
+			log.Items = append(log.Items, design.HistoryItem{
+				OriginalName: planned.OriginalName,
+				NewName:      name,
+				Size:         id.Size,
+				State:        design.ItemApplied,
+				ModTime:      id.ModTime,
+				Dev:          id.Dev,
+				Inode:        id.Inode,
+			})
+		}
+	}
+
+	if rollback {
+		if resp.FailCount == 0 {
+			if err := os.Remove(path); err != nil {
+				return resp, err
+			}
+		}
+		return resp, nil
+	}
+	if len(log.Items) > 0 {
+		if err := m.SaveHistory(&log); err != nil {
+			return resp, fmt.Errorf("%w: %v", ErrHistoryNotSaved, err)
+		}
+	}
+	return resp, os.Remove(path)
+}
+
+// readIntent reads an intent file. A torn last line, from a crash during the
+// write, is ignored.
+func readIntent(path string) (*pendingBatch, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p := &pendingBatch{intended: make(map[int]design.HistoryItem), done: make(map[int]string)}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		var rec intentRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			break
+		}
+		switch rec.Op {
+		case intentBegin:
+			p.log, p.planned = rec.Log, rec.Items
+		case intentIntend:
+			if rec.Item != nil {
+				p.intended[rec.Index] = *rec.Item
+			}
+		case intentDone:
+			p.done[rec.Index] = rec.Name
+		}
+	}
+	if p.log == nil {
+		return nil, fmt.Errorf("intent file %s has no begin record", filepath.Base(path))
+	}
+	return p, nil
+}
+
+func (p *pendingBatch) summary() design.PendingBatch {
+	b := design.PendingBatch{
+		ID:        p.log.ID,
+		Timestamp: p.log.Timestamp,
+		BasePath:  p.log.BasePath,
+		Mode:      p.log.Mode,
+		Items:     make([]design.PendingItem, 0, len(p.planned)),
+	}
+	for i := range p.planned {
+		state, item := p.state(i)
+		b.Items = append(b.Items, design.PendingItem{
+			OriginalName: item.OriginalName,
+			NewName:      item.NewName,
+			State:        state,
+		})
+	}
+	return b
+}
+
+// state finds out whether planned item i was renamed. It returns the item as
+// it would be recorded in the history.
+func (p *pendingBatch) state(i int) (string, design.HistoryItem) {
+	item := p.planned[i]
+	intended, ok := p.intended[i]
+	if ok {
+		// The intend record has the target after conflict resolution
+		intended.OriginalName = item.OriginalName
+		if intended.NewName == "" {
+			intended.NewName = item.NewName
+		}
+		intended.State = design.ItemApplied
+		item = intended
+	}
+	// A done rename may have been rolled back since, by an atomic batch
+	base := p.log.BasePath
+	if name, ok := p.done[i]; ok {
+		item.NewName = name
+		if _, err := os.Lstat(filepath.Join(base, name)); err == nil {
+			return design.PendingRenamed, item
+		}
+	}
+
+	// Without an intend record the rename never started
+	if ok {
+		if id, err := fs.Identify(filepath.Join(base, item.NewName), false); err == nil && sameIdentity(item, id) {
+			return design.PendingRenamed, item
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(base, item.OriginalName)); err == nil {
+		return design.PendingNotRenamed, item
+	}
+	return design.PendingUnknown, item
+}
+
+// sameIdentity reports whether id is the file recorded in item, by inode
+// where both are known and by size.
+func sameIdentity(item design.HistoryItem, id fs.Identity) bool {
+	if item.Inode != 0 && id.Inode != 0 && (item.Dev != id.Dev || item.Inode != id.Inode) {
+		return false
+	}
+	return item.Size == id.Size
+}
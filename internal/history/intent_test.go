@@ -0,0 +1,158 @@
+package history
+
+import (
+	"nas-renamer/design"
+	"nas-renamer/internal/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// crashedBatch leaves a batch of a.txt, b.txt and c.txt as a crash would:
+// a renamed and done, b renamed without its done record, c not started.
+func crashedBatch(t *testing.T, histDir, dir string) string {
+	m, err := openManager(histDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	planned := []design.HistoryItem{
+		{OriginalName: "a.txt", NewName: "A.txt"},
+		{OriginalName: "b.txt", NewName: "B.txt"},
+		{OriginalName: "c.txt", NewName: "C.txt"},
+	}
+	for i, p := range planned {
+		if err := os.WriteFile(filepath.Join(dir, p.OriginalName), []byte{byte(i)}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	id := uuid.New().String()
+	if err := m.Begin(&design.HistoryLog{ID: id, Timestamp: 1, BasePath: dir}, planned); err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range planned[:2] {
+		fid, _ := fs.Identify(filepath.Join(dir, p.OriginalName), false)
+		m.Intend(id, i, design.HistoryItem{Size: fid.Size, Dev: fid.Dev, Inode: fid.Inode})
+		if err := os.Rename(filepath.Join(dir, p.OriginalName), filepath.Join(dir, p.NewName)); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			m.Done(id, i, p.NewName)
+		}
+	}
+	m.Close()
+	return id
+}
+
+func TestCompletePending(t *testing.T) {
+	histDir, dir := t.TempDir(), t.TempDir()
+	id := crashedBatch(t, histDir, dir)
+
+	m, err := openManager(histDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	pending, err := m.Pending()
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("Expected one pending batch, got %v %v", pending, err)
+	}
+	want := []string{design.PendingRenamed, design.PendingRenamed, design.PendingNotRenamed}
+	for i, item := range pending[0].Items {
+		if item.State != want[i] {
+			t.Errorf("Item %s: expected %s, got %s", item.OriginalName, want[i], item.State)
+		}
+	}
+
+	resp, err := m.CompletePending(id)
+	if err != nil || resp.SuccessCount != 1 || resp.FailCount != 0 {
+		t.Fatalf("Complete failed: %v %+v", err, resp)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "C.txt")); err != nil {
+		t.Errorf("Expected c.txt to be renamed: %v", err)
+	}
+	log, err := m.GetLog(id)
+	if err != nil || len(log.Items) != 3 {
+		t.Fatalf("Expected the batch in history with 3 items, got %v %v", log, err)
+	}
+	if pending, _ := m.Pending(); len(pending) != 0 {
+		t.Errorf("Expected no pending batch after completing, got %d", len(pending))
+	}
+}
+
+func TestRollbackPending(t *testing.T) {
+	histDir, dir := t.TempDir(), t.TempDir()
+	id := crashedBatch(t, histDir, dir)
+
+	m, err := openManager(histDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	resp, err := m.RollbackPending(id)
+	if err != nil || resp.SuccessCount != 2 || resp.FailCount != 0 {
+		t.Fatalf("Rollback failed: %v %+v", err, resp)
+	}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected %s back: %v", name, err)
+		}
+	}
+	if _, err := m.GetLog(id); err == nil {
+		t.Error("Expected no history for a rolled back batch")
+	}
+	if pending, _ := m.Pending(); len(pending) != 0 {
+		t.Errorf("Expected no pending batch after rollback, got %d", len(pending))
+	}
+}
+
+func TestPendingSuffixedTarget(t *testing.T) {
+	histDir, dir := t.TempDir(), t.TempDir()
+	m, err := openManager(histDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(dir, "B.txt"), []byte("taken"), 0644)
+	planned := []design.HistoryItem{{OriginalName: "a.txt", NewName: "B.txt"}}
+	id := uuid.New().String()
+	if err := m.Begin(&design.HistoryLog{ID: id, Timestamp: 1, BasePath: dir}, planned); err != nil {
+		t.Fatal(err)
+	}
+
+	// Crash after a suffix rename, before its done record
+	oldPath := filepath.Join(dir, "a.txt")
+	target, err := fs.TargetName(oldPath, dir, "B.txt", design.ConflictSuffix)
+	if err != nil || target != "B (1).txt" {
+		t.Fatalf("Expected B (1).txt, got %q %v", target, err)
+	}
+	fid, _ := fs.Identify(oldPath, false)
+	m.Intend(id, 0, design.HistoryItem{NewName: target, Size: fid.Size, Dev: fid.Dev, Inode: fid.Inode})
+	if _, err := fs.RenameResolving(oldPath, dir, target, design.ConflictSuffix); err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+
+	m, err = openManager(histDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	pending, err := m.Pending()
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("Expected one pending batch, got %v %v", pending, err)
+	}
+	if item := pending[0].Items[0]; item.State != design.PendingRenamed || item.NewName != target {
+		t.Fatalf("Expected a renamed to %s, got %+v", target, item)
+	}
+	if _, err := m.CompletePending(id); err != nil {
+		t.Fatal(err)
+	}
+	log, err := m.GetLog(id)
+	if err != nil || len(log.Items) != 1 || log.Items[0].NewName != target {
+		t.Fatalf("Expected the suffixed name in history, got %+v %v", log, err)
+	}
+}
@@ -0,0 +1,429 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"nas-renamer/design"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	journalFile = "journal.log"
+	indexFile   = "journal.idx"
+
+	// Compact once superseded records take more space than this and than live ones
+	compactMinDead = 1 << 20
+)
+
+// Journal operations
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+// journalRecord is one line of the journal. A put of an existing ID
+// supersedes the earlier record.
+type journalRecord struct {
+	Op  string             `json:"op"`
+	ID  string             `json:"id"`
+	Log *design.HistoryLog `json:"log,omitempty"`
+}
+
+// indexEntry is one line of the index and points at the latest record of an ID.
+type indexEntry struct {
+	ID        string `json:"id"`
+	Offset    int64  `json:"off"`
+	Length    int64  `json:"len"`
+	Timestamp int64  `json:"ts,omitempty"`
+	ParentID  string `json:"parent,omitempty"`
+	Pinned    bool   `json:"pin,omitempty"`
+	Deleted   bool   `json:"del,omitempty"`
+
+	seq int // Order of first appearance, breaks timestamp ties
+}
+
+// journal is an append-only log of history records with an index kept in
+// memory and on disk. The index is only a cache: if it is missing or does not
+// match the journal, it is rebuilt by scanning the journal.
+type journal struct {
+	dir   string
+	file  *os.File // Journal, opened for appending
+	index *os.File // Opened for appending
+	size  int64    // Bytes in the journal
+
+	entries map[string]*indexEntry // Live entries by ID
+	order   []*indexEntry          // Live entries, oldest first
+	nextSeq int
+	dead    int64 // Bytes of superseded and delete records
+}
+
+func openJournal(dir string) (*journal, error) {
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	j := &journal{dir: dir, file: f, size: info.Size()}
+
+	if err := j.loadIndex(); err != nil {
+		if err := j.rebuildIndex(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if j.index, err = os.OpenFile(filepath.Join(dir, indexFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := j.maybeCompact(); err != nil {
+		j.close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// maybeCompact compacts the journal once it is mostly superseded records.
+func (j *journal) maybeCompact() error {
+	if j.dead > compactMinDead && j.dead > j.size-j.dead {
+		return j.compact()
+	}
+	return nil
+}
+
+func (j *journal) close() error {
+	err := j.file.Close()
+	if j.index != nil {
+		if ierr := j.index.Close(); err == nil {
+			err = ierr
+		}
+	}
+	return err
+}
+
+// loadIndex reads the index file. It fails if the index does not cover the
+// journal exactly, for example after a crash between the two writes.
+func (j *journal) loadIndex() error {
+	f, err := os.Open(filepath.Join(j.dir, indexFile))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	j.reset()
+	var end int64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e indexEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return err
+		}
+		// Gaps are damaged records left out by rebuildIndex
+		if e.Offset < end {
+			return fmt.Errorf("index overlap at offset %d", e.Offset)
+		}
+		end = e.Offset + e.Length
+		j.apply(&e)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if end != j.size {
+		return fmt.Errorf("index covers %d of %d journal bytes", end, j.size)
+	}
+	return nil
+}
+
+// rebuildIndex scans the journal and rewrites the index. A torn last record,
+// left by a crash during a write, is cut off. Lines that are not records are
+// left out of the index.
+func (j *journal) rebuildIndex() error {
+	j.reset()
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(j.file)
+	var entries []*indexEntry
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break // A line without newline is torn
+		}
+		if err != nil {
+			return err
+		}
+		// A damaged record in the middle is skipped, the records after it are kept
+		var rec journalRecord
+		if err := json.Unmarshal(line, &rec); err == nil && rec.ID != "" {
+			e := entryFor(&rec, offset, int64(len(line)))
+			entries = append(entries, e)
+			j.apply(e)
+		}
+		offset += int64(len(line))
+	}
+	if offset != j.size {
+		if err := j.file.Truncate(offset); err != nil {
+			return err
+		}
+		j.size = offset
+	}
+	return j.writeIndex(entries)
+}
+
+// writeIndex replaces the index file with entries.
+func (j *journal) writeIndex(entries []*indexEntry) error {
+	path := filepath.Join(j.dir, indexFile)
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	for _, e := range entries {
+		data, err := json.Marshal(e)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(data)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func entryFor(rec *journalRecord, offset, length int64) *indexEntry {
+	e := &indexEntry{ID: rec.ID, Offset: offset, Length: length, Deleted: rec.Op == opDelete}
+	if rec.Log != nil {
+		e.Timestamp = rec.Log.Timestamp
+		e.ParentID = rec.Log.ParentID
+		e.Pinned = rec.Log.Pinned
+	}
+	return e
+}
+
+func (j *journal) reset() {
+	j.entries = make(map[string]*indexEntry)
+	j.order = nil
+	j.nextSeq = 0
+	j.dead = 0
+}
+
+// apply updates the in-memory index with an entry read or just written.
+func (j *journal) apply(e *indexEntry) {
+	old := j.entries[e.ID]
+	if old != nil {
+		j.dead += old.Length
+		j.remove(old)
+		e.seq = old.seq
+	} else {
+		e.seq = j.nextSeq
+		j.nextSeq++
+	}
+	if e.Deleted {
+		delete(j.entries, e.ID)
+		j.dead += e.Length
+		return
+	}
+	j.entries[e.ID] = e
+
+	// Records mostly arrive in time order, so this is usually an append
+	i := sort.Search(len(j.order), func(i int) bool { return j.order[i].after(e) })
+	j.order = append(j.order, nil)
+	copy(j.order[i+1:], j.order[i:])
+	j.order[i] = e
+}
+
+func (j *journal) remove(e *indexEntry) {
+	for i, o := range j.order {
+		if o == e {
+			j.order = append(j.order[:i], j.order[i+1:]...)
+			return
+		}
+	}
+}
+
+func (e *indexEntry) after(o *indexEntry) bool {
+	if e.Timestamp != o.Timestamp {
+		return e.Timestamp > o.Timestamp
+	}
+	return e.seq > o.seq
+}
+
+// append writes a record to the journal, then to the index.
+func (j *journal) append(rec *journalRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	// Cut off a partly written record, so the next one starts on its own line
+	if _, err := j.file.Write(data); err != nil {
+		j.file.Truncate(j.size)
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		j.file.Truncate(j.size)
+		return err
+	}
+	e := entryFor(rec, j.size, int64(len(data)))
+	j.size += int64(len(data))
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	// A failed index write is repaired by the rebuild on next start
+	_, _ = j.index.Write(append(line, '\n'))
+	j.apply(e)
+	return nil
+}
+
+func (j *journal) put(log *design.HistoryLog) error {
+	return j.append(&journalRecord{Op: opPut, ID: log.ID, Log: log})
+}
+
+func (j *journal) delete(id string) error {
+	if j.entries[id] == nil {
+		return fmt.Errorf("%w: %s", ErrBatchNotFound, id)
+	}
+	return j.append(&journalRecord{Op: opDelete, ID: id})
+}
+
+func (j *journal) has(id string) bool {
+	return j.entries[id] != nil
+}
+
+// get reads the latest record of id with a single read.
+func (j *journal) get(id string) (*design.HistoryLog, error) {
+	e := j.entries[id]
+	if e == nil {
+		return nil, fmt.Errorf("%w: %s", ErrBatchNotFound, id)
+	}
+	return j.read(e)
+}
+
+func (j *journal) read(e *indexEntry) (*design.HistoryLog, error) {
+	buf := make([]byte, e.Length)
+	if _, err := j.file.ReadAt(buf, e.Offset); err != nil {
+		return nil, err
+	}
+	var rec journalRecord
+	if err := json.Unmarshal(buf, &rec); err != nil {
+		return nil, fmt.Errorf("corrupt history record %s: %w", e.ID, err)
+	}
+	if rec.ID != e.ID || rec.Log == nil {
+		return nil, errors.New("history index out of date")
+	}
+	return rec.Log, nil
+}
+
+// list returns up to limit entries, newest first, skipping offset of them.
+// A limit of 0 means all.
+func (j *journal) list(offset, limit int) ([]*design.HistoryLog, error) {
+	var logs []*design.HistoryLog
+	for i := len(j.order) - 1 - offset; i >= 0; i-- {
+		if limit > 0 && len(logs) == limit {
+			break
+		}
+		log, err := j.read(j.order[i])
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	return logs, nil
+}
+
+// children returns the entries whose parent is id, oldest first.
+func (j *journal) children(id string) ([]*design.HistoryLog, error) {
+	var logs []*design.HistoryLog
+	for _, e := range j.order {
+		if e.ParentID != id {
+			continue
+		}
+		log, err := j.read(e)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	return logs, nil
+}
+
+// compact rewrites the journal with live records only, in their current order.
+func (j *journal) compact() error {
+	path := filepath.Join(j.dir, journalFile)
+	// Opened for appending now, so nothing has to be opened after the swap
+	tmp, err := os.OpenFile(path+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	discard := func(err error) error {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	entries := make([]*indexEntry, 0, len(j.order))
+	var offset int64
+	for _, e := range j.order {
+		buf := make([]byte, e.Length)
+		if _, err := j.file.ReadAt(buf, e.Offset); err != nil {
+			return discard(err)
+		}
+		w.Write(buf)
+		moved := *e
+		moved.Offset = offset
+		entries = append(entries, &moved)
+		offset += e.Length
+	}
+	if err := w.Flush(); err != nil {
+		return discard(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return discard(err)
+	}
+
+	// Without an index the next start rebuilds it, so a crash in between is
+	// safe. The old index stays open until the new one is in place: records
+	// appended to it meanwhile are lost, which only costs that rebuild.
+	if err := os.Remove(filepath.Join(j.dir, indexFile)); err != nil && !os.IsNotExist(err) {
+		return discard(err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return discard(err)
+	}
+	j.file.Close()
+	j.file = tmp
+	j.size = offset
+	j.reset()
+	for _, e := range entries {
+		j.apply(e)
+	}
+
+	if err := j.writeIndex(entries); err != nil {
+		return err
+	}
+	index, err := os.OpenFile(filepath.Join(j.dir, indexFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	j.index.Close()
+	j.index = index
+	return nil
+}
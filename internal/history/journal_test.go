@@ -0,0 +1,393 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nas-renamer/design"
+	"nas-renamer/internal/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournalReopenAndMigrate(t *testing.T) {
+	dir := t.TempDir()
+
+	// A batch in the old one-file-per-batch format
+	legacy := design.HistoryLog{ID: "old", Timestamp: 1, Items: []design.HistoryItem{{OriginalName: "a", NewName: "b"}}}
+	data, _ := json.Marshal(legacy)
+	os.WriteFile(filepath.Join(dir, "1_old.json"), data, 0644)
+
+	m, err := openManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 2; i <= 4; i++ {
+		if err := m.SaveHistory(&design.HistoryLog{ID: fmt.Sprint("b", i), Timestamp: int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Updating a batch keeps a single entry
+	if err := m.SaveHistory(&design.HistoryLog{ID: "b2", Timestamp: 2, Mode: "quick"}); err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, legacyDir, "1_old.json")); err != nil {
+		t.Errorf("Expected the legacy file to be moved: %v", err)
+	}
+
+	m, err = openManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	logs, total, err := m.ListHistory(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 4 || len(logs) != 2 || logs[0].ID != "b3" || logs[1].ID != "b2" {
+		t.Fatalf("Unexpected page (total %d): %+v", total, logs)
+	}
+	if logs[1].Mode != "quick" {
+		t.Error("Expected the latest version of b2")
+	}
+	if log, err := m.GetLog("old"); err != nil || log.Items[0].NewName != "b" {
+		t.Errorf("Expected the migrated batch, got %+v, %v", log, err)
+	}
+}
+
+func TestJournalRecoversTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	m, err := openManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SaveHistory(&design.HistoryLog{ID: "a", Timestamp: 1})
+	m.SaveHistory(&design.HistoryLog{ID: "b", Timestamp: 2})
+	m.Close()
+
+	// Simulate a crash in the middle of writing a record, and a lost index
+	f, _ := os.OpenFile(filepath.Join(dir, journalFile), os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString(`{"op":"put","id":"c","log":{"id":`)
+	f.Close()
+	os.Remove(filepath.Join(dir, indexFile))
+
+	m, err = openManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	logs, err := m.GetHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 || logs[0].ID != "b" {
+		t.Fatalf("Expected batches b and a, got %+v", logs)
+	}
+	if err := m.SaveHistory(&design.HistoryLog{ID: "c", Timestamp: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetLog("c"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJournalSkipsDamagedRecord(t *testing.T) {
+	dir := t.TempDir()
+	m, err := openManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range []string{"a", "b", "c"} {
+		m.SaveHistory(&design.HistoryLog{ID: id, Timestamp: int64(i + 1)})
+	}
+	m.Close()
+
+	// Damage the middle record in place and lose the index
+	path := filepath.Join(dir, journalFile)
+	data, _ := os.ReadFile(path)
+	second := strings.IndexByte(string(data), '\n') + 1
+	data[second] = '?'
+	os.WriteFile(path, data, 0644)
+	os.Remove(filepath.Join(dir, indexFile))
+
+	// Rebuilt from the journal, then loaded from the index with its gap
+	for i := 0; i < 2; i++ {
+		m, err = openManager(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		logs, err := m.GetHistory()
+		m.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(logs) != 2 || logs[0].ID != "c" || logs[1].ID != "a" {
+			t.Fatalf("Expected batches c and a, got %+v", logs)
+		}
+	}
+}
+
+func TestJournalCompact(t *testing.T) {
+	dir := t.TempDir()
+	m, err := openManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		m.SaveHistory(&design.HistoryLog{ID: "a", Timestamp: 1, Mode: fmt.Sprint(i)})
+	}
+	m.SaveHistory(&design.HistoryLog{ID: "b", Timestamp: 2})
+	m.journal.delete("b")
+
+	if err := m.journal.compact(); err != nil {
+		t.Fatal(err)
+	}
+	if m.journal.dead != 0 || len(m.journal.order) != 1 {
+		t.Errorf("Expected one live record and no dead bytes, got %d, %d", len(m.journal.order), m.journal.dead)
+	}
+	log, err := m.GetLog("a")
+	if err != nil || log.Mode != "4" {
+		t.Errorf("Expected the latest version of a, got %+v, %v", log, err)
+	}
+	if err := m.SaveHistory(&design.HistoryLog{ID: "c", Timestamp: 3}); err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+
+	m, err = openManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if logs, _ := m.GetHistory(); len(logs) != 2 {
+		t.Errorf("Expected 2 batches after reopening, got %d", len(logs))
+	}
+}
+
+func TestJournalCompactIndexFailure(t *testing.T) {
+	dir := t.TempDir()
+	m, err := openManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SaveHistory(&design.HistoryLog{ID: "a", Timestamp: 1})
+	m.SaveHistory(&design.HistoryLog{ID: "a", Timestamp: 1, Mode: "quick"})
+
+	// The new index cannot be written
+	blocker := filepath.Join(dir, indexFile+".tmp")
+	os.Mkdir(blocker, 0755)
+	if err := m.journal.compact(); err == nil {
+		t.Fatal("Expected the index write to fail")
+	}
+	if _, err := m.journal.index.Stat(); err != nil {
+		t.Fatalf("Expected the index to stay open: %v", err)
+	}
+	if err := m.SaveHistory(&design.HistoryLog{ID: "b", Timestamp: 2}); err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+	os.Remove(blocker)
+
+	m, err = openManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	logs, _ := m.GetHistory()
+	if len(logs) != 2 || logs[1].Mode != "quick" {
+		t.Errorf("Expected b and the latest a after reopening, got %+v", logs)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	m, err := openManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	now := time.Unix(100*86400, 0)
+	day := int64(86400)
+	m.SaveHistory(&design.HistoryLog{ID: "old", Timestamp: now.Unix() - 30*day})
+	m.SaveHistory(&design.HistoryLog{ID: "pinned", Timestamp: now.Unix() - 20*day, Pinned: true})
+	m.SaveHistory(&design.HistoryLog{ID: "undone", Timestamp: now.Unix() - 20*day})
+	m.SaveHistory(&design.HistoryLog{ID: "undo", Timestamp: now.Unix() - 1*day, ParentID: "undone", Kind: design.KindUndo})
+	m.SaveHistory(&design.HistoryLog{ID: "new", Timestamp: now.Unix()})
+
+	// Age counts from the latest undo, so "undone" stays with its undo
+	removed, err := m.Prune(design.RetentionPolicy{MaxAgeDays: 10}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != "old" {
+		t.Errorf("Expected only old to be pruned, got %v", removed)
+	}
+
+	// Count ignores undo entries and never removes pinned batches
+	removed, err = m.Prune(design.RetentionPolicy{MaxCount: 1}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 || removed[0] != "undone" || removed[1] != "undo" {
+		t.Errorf("Expected undone and its undo to be pruned, got %v", removed)
+	}
+	logs, _ := m.GetHistory()
+	if len(logs) != 2 {
+		t.Errorf("Expected pinned and new to remain, got %d batches", len(logs))
+	}
+
+	// An entry whose parent is itself a child does not panic and is pruned alone
+	m.SaveHistory(&design.HistoryLog{ID: "redo", Timestamp: now.Unix(), ParentID: "new", Kind: design.KindRedo})
+	m.SaveHistory(&design.HistoryLog{ID: "nested", Timestamp: now.Unix() - 30*day, ParentID: "redo", Kind: design.KindUndo})
+	removed, err = m.Prune(design.RetentionPolicy{MaxAgeDays: 10}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != "nested" {
+		t.Errorf("Expected only nested to be pruned, got %v", removed)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	m, err := openManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	m.SaveHistory(&design.HistoryLog{ID: "a", Timestamp: 100, BasePath: "/data/movies", Mode: design.ModeQuick,
+		Items: []design.HistoryItem{{OriginalName: "Film.2020.x264.mkv", NewName: "Film (2020).mkv"}, {OriginalName: "b.txt", NewName: "c.txt"}}})
+	m.SaveHistory(&design.HistoryLog{ID: "b", Timestamp: 200, BasePath: "/data/movies-old", Mode: design.ModeBasic,
+		Items: []design.HistoryItem{{OriginalName: "x.mkv", NewName: "y.mkv", State: design.ItemReverted}}})
+	m.SaveHistory(&design.HistoryLog{ID: "c", Timestamp: 300, BasePath: "/data/movies/2021", Mode: design.ModeQuick,
+		Items: []design.HistoryItem{{OriginalName: "film.mkv", NewName: "Film.mkv"}}})
+
+	ids := func(logs []*design.HistoryLog) string {
+		var s []string
+		for _, l := range logs {
+			s = append(s, l.ID)
+		}
+		return strings.Join(s, ",")
+	}
+	tests := []struct {
+		q    design.HistoryQuery
+		want string
+	}{
+		{design.HistoryQuery{}, "c,b,a"},
+		{design.HistoryQuery{Path: "/data/movies"}, "c,a"},
+		{design.HistoryQuery{Name: "FILM"}, "c,a"},
+		{design.HistoryQuery{From: 150, To: 300}, "c,b"},
+		{design.HistoryQuery{Mode: design.ModeBasic}, "b"},
+		{design.HistoryQuery{Status: design.StatusUndone}, "b"},
+	}
+	for _, tt := range tests {
+		res, err := m.Search(tt.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(res.Logs); got != tt.want {
+			t.Errorf("Search(%+v) = %s, want %s", tt.q, got, tt.want)
+		}
+	}
+
+	res, _ := m.Search(design.HistoryQuery{Name: "film"})
+	if len(res.Logs[1].Items) != 1 {
+		t.Errorf("Expected only the matching item, got %v", res.Logs[1].Items)
+	}
+
+	// The date range is counted from the index, other filters on request
+	counts := []struct {
+		q     design.HistoryQuery
+		total int
+	}{
+		{design.HistoryQuery{From: 150, Limit: 1}, 2},
+		{design.HistoryQuery{Path: "/data/movies", Limit: 1}, -1},
+		{design.HistoryQuery{Path: "/data/movies", Limit: 1, Count: true}, 2},
+	}
+	for _, tt := range counts {
+		res, _ := m.Search(tt.q)
+		if ids(res.Logs) != "c" || res.Total != tt.total || res.Next == "" {
+			t.Errorf("Search(%+v) = %s, total %d, next %q", tt.q, ids(res.Logs), res.Total, res.Next)
+		}
+	}
+
+	// Pages follow the cursor, and a new entry does not shift them
+	res, _ = m.Search(design.HistoryQuery{Limit: 2})
+	if ids(res.Logs) != "c,b" || res.Total != 3 || res.Next == "" {
+		t.Fatalf("Unexpected first page %s, total %d, next %q", ids(res.Logs), res.Total, res.Next)
+	}
+	m.SaveHistory(&design.HistoryLog{ID: "d", Timestamp: 400})
+	res, _ = m.Search(design.HistoryQuery{Limit: 2, Cursor: res.Next})
+	if ids(res.Logs) != "a" || res.Next != "" {
+		t.Errorf("Unexpected second page %s, next %q", ids(res.Logs), res.Next)
+	}
+
+	if _, err := m.Search(design.HistoryQuery{Cursor: "bogus"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestFileLineageAndRestore(t *testing.T) {
+	m, err := openManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.mkv")
+	if err := os.WriteFile(path, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rename := func(id, from, to string, ts int64) {
+		if err := os.Rename(filepath.Join(dir, from), filepath.Join(dir, to)); err != nil {
+			t.Fatal(err)
+		}
+		fid, _ := fs.Identify(filepath.Join(dir, to), false)
+		m.SaveHistory(&design.HistoryLog{ID: id, Timestamp: ts, BasePath: dir, Items: []design.HistoryItem{{
+			OriginalName: from, NewName: to, Size: fid.Size, Dev: fid.Dev, Inode: fid.Inode,
+		}}})
+	}
+	rename("1", "a.mkv", "b.mkv", 1)
+	rename("2", "b.mkv", "c.mkv", 2)
+	if _, err := m.Undo("2", UndoOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	lineage, err := m.FileLineage(filepath.Join(dir, "b.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var chain []string
+	for _, r := range lineage.Renames {
+		chain = append(chain, r.From+">"+r.To)
+	}
+	if got, want := strings.Join(chain, " "), "a.mkv>b.mkv b.mkv>c.mkv c.mkv>b.mkv"; got != want {
+		t.Errorf("Lineage = %s, want %s", got, want)
+	}
+
+	if _, err := m.RestoreName(filepath.Join(dir, "b.mkv"), "other.mkv", "", false); !errors.Is(err, ErrNameNotInLineage) {
+		t.Errorf("Expected ErrNameNotInLineage, got %v", err)
+	}
+	resp, err := m.RestoreName(filepath.Join(dir, "b.mkv"), "a.mkv", "", false)
+	if err != nil || resp.SuccessCount != 1 {
+		t.Fatalf("Restore failed: %v %+v", err, resp)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected the file back at a.mkv: %v", err)
+	}
+	// The restore is a batch of its own and can be undone
+	if _, err := m.Undo(resp.BatchID, UndoOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.mkv")); err != nil {
+		t.Errorf("Expected undo of the restore to bring back b.mkv: %v", err)
+	}
+}
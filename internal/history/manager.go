@@ -2,139 +2,140 @@ package history
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"nas-renamer/design"
 	"os"
 	"path/filepath"
-	"sort"
 	"sync"
 )
 
+var (
+	// ErrBatchNotFound is returned for an unknown batch ID.
+	ErrBatchNotFound = errors.New("batch not found")
+	// ErrHistoryNotSaved is returned, with the result, when files were renamed
+	// but the history entry recording it could not be saved.
+	ErrHistoryNotSaved = errors.New("files were renamed but history was not saved")
+)
+
+// legacyDir receives the one-file-per-batch history after migration.
+const legacyDir = "legacy"
+
 type Manager struct {
 	mu      sync.Mutex
 	baseDir string
+	journal *journal
+	intents intents
 }
 
-func NewManager() (*Manager, error) {
-	// Store history in a .history dir in the current working directory
-	// In a real NAS scenario, this might need to be config driven.
-	cwd, err := os.Getwd()
-	if err != nil {
-		return nil, err
+// NewManager opens the history in dir, creating it if needed. An empty dir
+// means a .history dir in the current working directory.
+func NewManager(dir string) (*Manager, error) {
+	if dir == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(cwd, ".history")
 	}
-	histDir := filepath.Join(cwd, ".history")
+	return openManager(dir)
+}
+
+func openManager(histDir string) (*Manager, error) {
 	if err := os.MkdirAll(histDir, 0755); err != nil {
 		return nil, err
 	}
-	return &Manager{baseDir: histDir}, nil
-}
-
-func (m *Manager) SaveHistory(log *design.HistoryLog) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	fileName := fmt.Sprintf("%d_%s.json", log.Timestamp, log.ID)
-	filePath := filepath.Join(m.baseDir, fileName)
-
-	data, err := json.MarshalIndent(log, "", "  ")
+	j, err := openJournal(histDir)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	m := &Manager{baseDir: histDir, journal: j, intents: intents{active: make(map[string]*os.File)}}
+	if err := m.migrate(); err != nil {
+		j.close()
+		return nil, fmt.Errorf("migrate history: %w", err)
 	}
+	return m, nil
+}
 
-	return os.WriteFile(filePath, data, 0644)
+// Dir returns the directory the history is kept in.
+func (m *Manager) Dir() string {
+	return m.baseDir
 }
 
-func (m *Manager) GetHistory() ([]*design.HistoryLog, error) {
+// Close releases the history files.
+func (m *Manager) Close() error {
+	m.intents.mu.Lock()
+	for id, f := range m.intents.active {
+		f.Close()
+		delete(m.intents.active, id)
+	}
+	m.intents.mu.Unlock()
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	return m.journal.close()
+}
 
-	entries, err := os.ReadDir(m.baseDir)
-	if err != nil {
-		return nil, err
+// migrate moves batches stored as one JSON file each (the format before the
+// journal) into the journal, then moves the files to legacyDir.
+func (m *Manager) migrate() error {
+	files, err := filepath.Glob(filepath.Join(m.baseDir, "*.json"))
+	if err != nil || len(files) == 0 {
+		return err
 	}
-
-	var logs []*design.HistoryLog
-	for _, entry := range entries {
-		if filepath.Ext(entry.Name()) != ".json" {
-			continue
-		}
-
-		data, err := os.ReadFile(filepath.Join(m.baseDir, entry.Name()))
+	if err := os.MkdirAll(filepath.Join(m.baseDir, legacyDir), 0755); err != nil {
+		return err
+	}
+	for _, path := range files {
+		data, err := os.ReadFile(path)
 		if err != nil {
-			continue // Skip unreadable
+			return err
 		}
-
 		var log design.HistoryLog
-		if err := json.Unmarshal(data, &log); err != nil {
-			continue // Skip content error
+		if err := json.Unmarshal(data, &log); err != nil || log.ID == "" {
+			continue // Leave unreadable files where they are
+		}
+		if !m.journal.has(log.ID) {
+			log.Status = batchStatus(log.Items)
+			if err := m.journal.put(&log); err != nil {
+				return err
+			}
+		}
+		if err := os.Rename(path, filepath.Join(m.baseDir, legacyDir, filepath.Base(path))); err != nil {
+			return err
 		}
-		logs = append(logs, &log)
 	}
+	return nil
+}
 
-	// Sort by timestamp desc
-	sort.Slice(logs, func(i, j int) bool {
-		return logs[i].Timestamp > logs[j].Timestamp
-	})
+// SaveHistory stores a batch, replacing an earlier version with the same ID.
+func (m *Manager) SaveHistory(log *design.HistoryLog) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	return logs, nil
+	log.Status = batchStatus(log.Items)
+	return m.journal.put(log)
 }
 
-func (m *Manager) GetLog(batchID string) (*design.HistoryLog, error) {
-	// Optimization: could map ID to filename, but for now scan is okay for small history
-	logs, err := m.GetHistory()
-	if err != nil {
-		return nil, err
-	}
-	for _, log := range logs {
-		if log.ID == batchID {
-			return log, nil
-		}
-	}
-	return nil, fmt.Errorf("batch not found: %s", batchID)
+// GetHistory returns every batch, newest first.
+func (m *Manager) GetHistory() ([]*design.HistoryLog, error) {
+	logs, _, err := m.ListHistory(0, 0)
+	return logs, err
 }
 
-func (m *Manager) Undo(batchID string) (*design.ExecuteResponse, error) {
-	log, err := m.GetLog(batchID)
-	if err != nil {
-		return nil, err
-	}
-
-	successCount := 0
-	failCount := 0
-	var errors []string
-
-	// Reverse operation: NewName -> OriginalName
-	for _, item := range log.Items {
-		currentPath := filepath.Join(log.BasePath, item.NewName)
-		originalPath := filepath.Join(log.BasePath, item.OriginalName)
-
-		// Safety check: Does current match what we expect?
-		info, err := os.Stat(currentPath)
-		if os.IsNotExist(err) {
-			failCount++
-			errors = append(errors, fmt.Sprintf("File missing: %s", item.NewName))
-			continue
-		}
+// ListHistory returns a page of batches, newest first, and the number of batches.
+// A limit of 0 means no limit.
+func (m *Manager) ListHistory(offset, limit int) ([]*design.HistoryLog, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-		// Optional: Could check size/modtime to be safer
-		if info.Size() != item.Size {
-			// Warn but proceed? Or fail? design says "Size used for safety check"
-			// Let's be strict for now or just log it.
-			// failCount++; continue;
-		}
+	logs, err := m.journal.list(offset, limit)
+	return logs, len(m.journal.order), err
+}
 
-		if err := os.Rename(currentPath, originalPath); err != nil {
-			failCount++
-			errors = append(errors, fmt.Sprintf("Failed to restore %s: %v", item.OriginalName, err))
-		} else {
-			successCount++
-		}
-	}
+func (m *Manager) GetLog(batchID string) (*design.HistoryLog, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	return &design.ExecuteResponse{
-		BatchID:      batchID + "-undo",
-		SuccessCount: successCount,
-		FailCount:    failCount,
-		Errors:       errors,
-	}, nil
+	return m.journal.get(batchID)
 }
@@ -0,0 +1,160 @@
+package history
+
+import (
+	"nas-renamer/design"
+	"sort"
+	"time"
+)
+
+// family is a batch with its undo and redo entries. Retention keeps or
+// removes a family as a whole.
+type family struct {
+	root    *indexEntry
+	members []*indexEntry // Root first
+	latest  int64         // Newest timestamp of any member
+	size    int64         // Journal bytes of all members
+}
+
+// families groups the live entries, oldest batch first.
+func (j *journal) families() []*family {
+	byRoot := make(map[string]*family)
+	var list []*family
+	for _, e := range j.order {
+		if e.ParentID == "" || j.entries[e.ParentID] == nil {
+			f := &family{root: e}
+			byRoot[e.ID] = f
+			list = append(list, f)
+		}
+	}
+	for _, e := range j.order {
+		f := byRoot[e.ID]
+		if f == nil {
+			f = byRoot[e.ParentID]
+		}
+		if f == nil {
+			// The parent is itself a child, so this entry heads its own family
+			f = &family{root: e}
+			byRoot[e.ID] = f
+			list = append(list, f)
+		}
+		f.members = append(f.members, e)
+		f.latest = max(f.latest, e.Timestamp)
+		f.size += e.Length
+	}
+	sort.SliceStable(list, func(a, b int) bool { return list[a].latest < list[b].latest })
+	return list
+}
+
+// SetPinned pins or unpins a batch. Pinned batches are never pruned.
+func (m *Manager) SetPinned(batchID string, pinned bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	log, err := m.journal.get(batchID)
+	if err != nil {
+		return err
+	}
+	if log.Pinned == pinned {
+		return nil
+	}
+	log.Pinned = pinned
+	return m.journal.put(log)
+}
+
+// Prune removes the oldest unpinned batches until the policy holds, and
+// returns the IDs of every removed entry. Pinned batches do not count
+// towards the count and size limits.
+func (m *Manager) Prune(p design.RetentionPolicy, now time.Time) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Pinned batches are kept outside the limits
+	families := m.journal.families()
+	count := 0
+	var size int64
+	for _, f := range families {
+		if !f.root.Pinned {
+			count++
+			size += f.size
+		}
+	}
+	maxSize := int64(p.MaxSizeMB) << 20
+	cutoff := now.AddDate(0, 0, -p.MaxAgeDays).Unix()
+
+	var removed []string
+	for _, f := range families {
+		tooOld := p.MaxAgeDays > 0 && f.latest < cutoff
+		tooMany := p.MaxCount > 0 && count > p.MaxCount
+		tooBig := p.MaxSizeMB > 0 && size > maxSize
+		if !tooOld && !tooMany && !tooBig {
+			break // Families are oldest first, the rest are kept too
+		}
+		if f.root.Pinned {
+			continue
+		}
+		ids, err := m.removeFamily(f)
+		removed = append(removed, ids...)
+		if err != nil {
+			return removed, err
+		}
+		count--
+		size -= f.size
+	}
+	return removed, m.journal.maybeCompact()
+}
+
+// DeleteBefore removes unpinned batches whose latest activity is before t,
+// and returns the IDs of every removed entry.
+func (m *Manager) DeleteBefore(t time.Time) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var removed []string
+	for _, f := range m.journal.families() {
+		if f.latest >= t.Unix() {
+			break
+		}
+		if f.root.Pinned {
+			continue
+		}
+		ids, err := m.removeFamily(f)
+		removed = append(removed, ids...)
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, m.journal.maybeCompact()
+}
+
+// Export returns the batches whose latest activity is before t, with their
+// undo and redo entries, oldest first. A zero t exports everything.
+func (m *Manager) Export(t time.Time) ([]*design.HistoryLog, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	logs := []*design.HistoryLog{}
+	for _, f := range m.journal.families() {
+		if !t.IsZero() && f.latest >= t.Unix() {
+			break
+		}
+		for _, e := range f.members {
+			log, err := m.journal.read(e)
+			if err != nil {
+				return nil, err
+			}
+			logs = append(logs, log)
+		}
+	}
+	return logs, nil
+}
+
+func (m *Manager) removeFamily(f *family) ([]string, error) {
+	var ids []string
+	for _, e := range f.members {
+		if err := m.journal.delete(e.ID); err != nil {
+			return ids, err
+		}
+		ids = append(ids, e.ID)
+	}
+	return ids, nil
+}
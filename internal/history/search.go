@@ -0,0 +1,210 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"nas-renamer/design"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidCursor is returned for a cursor that was not issued by Search.
+var ErrInvalidCursor = errors.New("invalid history cursor")
+
+// SearchResult is a page of history entries.
+type SearchResult struct {
+	Logs []*design.HistoryLog
+	// Entries matching the query from the cursor on, or from the newest
+	// without one. -1 when not counted, see design.HistoryQuery.Count.
+	Total int
+	Next  string // Cursor of the next page, empty on the last page
+}
+
+// Search returns the entries matching q, newest first. With a name filter
+// only the matching items of each entry are returned, the whole entry is
+// available from GetLog. Only the entries of the page are read when q has
+// no filters besides the date range, which the index answers. Otherwise
+// reading stops once the page is full, unless q.Count asks for the total.
+func (m *Manager) Search(q design.HistoryQuery) (*SearchResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	order := m.journal.order
+	start, err := m.cursorStart(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	match := newHistoryMatcher(q)
+	needsLog := match.needsLog()
+	counted := q.Count || !needsLog
+	res := &SearchResult{Logs: []*design.HistoryLog{}, Total: -1}
+	if counted {
+		res.Total = 0
+	}
+	skipped := 0
+	var last *indexEntry
+	for i := start; i >= 0; i-- {
+		e := order[i]
+		if !match.timestamp(e.Timestamp) {
+			continue
+		}
+		inPage := skipped >= q.Offset && (q.Limit == 0 || len(res.Logs) < q.Limit)
+		var log *design.HistoryLog
+		if needsLog || inPage {
+			if log, err = m.journal.read(e); err != nil {
+				return nil, err
+			}
+			if !match.log(log) {
+				continue
+			}
+		}
+		if counted {
+			res.Total++
+		}
+		switch {
+		case skipped < q.Offset:
+			skipped++
+		case inPage:
+			res.Logs = append(res.Logs, log)
+			last = e
+		default:
+			// The page is full and another entry matches
+			if res.Next == "" {
+				res.Next = encodeCursor(last)
+			}
+			if !counted {
+				return res, nil
+			}
+		}
+	}
+	return res, nil
+}
+
+// cursorStart returns the index in the journal order of the newest entry
+// after cursor, -1 if there is none.
+func (m *Manager) cursorStart(cursor string) (int, error) {
+	order := m.journal.order
+	if cursor == "" {
+		return len(order) - 1, nil
+	}
+	ts, id, ok := strings.Cut(cursor, ":")
+	timestamp, err := strconv.ParseInt(ts, 10, 64)
+	if !ok || err != nil || id == "" {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidCursor, cursor)
+	}
+
+	if e := m.journal.entries[id]; e != nil && e.Timestamp == timestamp {
+		for i, o := range order {
+			if o == e {
+				return i - 1, nil
+			}
+		}
+	}
+	// The entry is gone, continue with older timestamps
+	for i := len(order) - 1; i >= 0; i-- {
+		if order[i].Timestamp < timestamp {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+// encodeCursor identifies the last entry of a page. Entries are only ever
+// added with newer timestamps, so the next page stays stable.
+func encodeCursor(e *indexEntry) string {
+	return fmt.Sprintf("%d:%s", e.Timestamp, e.ID)
+}
+
+type historyMatcher struct {
+	q    design.HistoryQuery
+	path string
+	name string
+}
+
+func newHistoryMatcher(q design.HistoryQuery) *historyMatcher {
+	m := &historyMatcher{q: q, name: strings.ToLower(q.Name)}
+	if q.Path != "" {
+		m.path = filepath.Clean(q.Path)
+	}
+	return m
+}
+
+// needsLog reports whether the query filters on more than the index holds.
+func (m *historyMatcher) needsLog() bool {
+	return m.path != "" || m.name != "" || m.q.Mode != "" || m.q.Kind != "" || m.q.Status != ""
+}
+
+// timestamp checks the date range, which the index alone can answer.
+func (m *historyMatcher) timestamp(ts int64) bool {
+	if ts < m.q.From {
+		return false
+	}
+	return m.q.To == 0 || ts <= m.q.To
+}
+
+// log checks the other filters and narrows the items to those matching the
+// name filter.
+func (m *historyMatcher) log(log *design.HistoryLog) bool {
+	if m.path != "" && !underPath(filepath.Clean(log.BasePath), m.path) {
+		return false
+	}
+	if m.q.Mode != "" && log.Mode != m.q.Mode {
+		return false
+	}
+	if m.q.Kind != "" && kindOf(log) != m.q.Kind {
+		return false
+	}
+	if m.q.Status != "" && !m.status(log) {
+		return false
+	}
+	if m.name == "" {
+		return true
+	}
+	var items []design.HistoryItem
+	for _, item := range log.Items {
+		if m.matchesName(item) {
+			items = append(items, item)
+		}
+	}
+	log.Items = items
+	return len(items) > 0
+}
+
+func (m *historyMatcher) status(log *design.HistoryLog) bool {
+	status := log.Status
+	if status == "" {
+		status = design.BatchApplied
+	}
+	if m.q.Status == design.StatusUndone {
+		return status != design.BatchApplied
+	}
+	return status == m.q.Status
+}
+
+func (m *historyMatcher) matchesName(item design.HistoryItem) bool {
+	for _, name := range []string{item.OriginalName, item.NewName, item.RestoredAs} {
+		if name != "" && strings.Contains(strings.ToLower(name), m.name) {
+			return true
+		}
+	}
+	return false
+}
+
+func kindOf(log *design.HistoryLog) string {
+	if log.Kind == "" {
+		return design.KindExecute
+	}
+	return log.Kind
+}
+
+// underPath reports whether path is dir or inside it.
+func underPath(path, dir string) bool {
+	if path == dir {
+		return true
+	}
+	if !strings.HasSuffix(dir, string(filepath.Separator)) {
+		dir += string(filepath.Separator)
+	}
+	return strings.HasPrefix(path, dir)
+}
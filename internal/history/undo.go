@@ -0,0 +1,323 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"nas-renamer/design"
+	"nas-renamer/internal/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrItemNotFound is returned when an undo selects an item that is not in the batch.
+	ErrItemNotFound = errors.New("item not found in batch")
+	// ErrNotUndoable is returned for undo or redo of an undo or redo entry.
+	// Those are replayed through the batch they belong to.
+	ErrNotUndoable = errors.New("undo and redo entries cannot be undone or redone, use the original batch")
+)
+
+// UndoOptions select and check the items of an undo or redo.
+type UndoOptions struct {
+	Items      []string // NewName of the items, empty means all
+	Verify     string   // See design.Verify* values, empty means strict
+	OnConflict string   // See design.Conflict* values, empty means skip
+}
+
+// undoStep is the plan for one history item, by its index in the batch.
+type undoStep struct {
+	index    int
+	from, to string // Current name and the name the file gets
+	item     design.UndoPreviewItem
+}
+
+// PreviewUndo reports what undoing a batch, or the selected items of it,
+// would do without touching files.
+func (m *Manager) PreviewUndo(batchID string, opts UndoOptions) (*design.UndoPreviewResponse, error) {
+	return m.preview(batchID, false, opts)
+}
+
+// PreviewRedo is PreviewUndo for re-applying undone items.
+func (m *Manager) PreviewRedo(batchID string, opts UndoOptions) (*design.UndoPreviewResponse, error) {
+	return m.preview(batchID, true, opts)
+}
+
+// Undo renames the selected items of a batch back to their original names.
+// Items that are not restorable are reported and left alone; items already
+// restored are skipped. Files are never replaced. The undo is saved as its
+// own history entry, linked to the batch. If saving fails the files are
+// renamed all the same, and the response comes with ErrHistoryNotSaved.
+func (m *Manager) Undo(batchID string, opts UndoOptions) (*design.ExecuteResponse, error) {
+	return m.replay(batchID, false, opts)
+}
+
+// Redo renames undone items of a batch to their new names again, with the
+// same checks as Undo.
+func (m *Manager) Redo(batchID string, opts UndoOptions) (*design.ExecuteResponse, error) {
+	return m.replay(batchID, true, opts)
+}
+
+func (m *Manager) preview(batchID string, redo bool, opts UndoOptions) (*design.UndoPreviewResponse, error) {
+	log, err := m.GetLog(batchID)
+	if err != nil {
+		return nil, err
+	}
+	steps, err := planUndo(log, redo, opts)
+	if err != nil {
+		return nil, err
+	}
+	resp := &design.UndoPreviewResponse{
+		BatchID: batchID,
+		Items:   make([]design.UndoPreviewItem, 0, len(steps)),
+	}
+	for _, s := range steps {
+		resp.Items = append(resp.Items, s.item)
+	}
+	return resp, nil
+}
+
+func (m *Manager) replay(batchID string, redo bool, opts UndoOptions) (*design.ExecuteResponse, error) {
+	log, err := m.GetLog(batchID)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := fs.LockDir(log.BasePath)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	steps, err := planUndo(log, redo, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &design.HistoryLog{
+		ID:        uuid.New().String(),
+		Timestamp: time.Now().Unix(),
+		BasePath:  log.BasePath,
+		Mode:      log.Mode,
+		Kind:      design.KindUndo,
+		ParentID:  log.ID,
+	}
+	if redo {
+		entry.Kind = design.KindRedo
+	}
+
+	successCount := 0
+	failCount := 0
+	var errors []string
+
+	for _, step := range steps {
+		item := step.item
+		if item.Status == design.UndoReverted || item.Status == design.UndoApplied {
+			continue
+		}
+		if item.Status != design.UndoRestorable {
+			failCount++
+			errors = append(errors, item.Message)
+			continue
+		}
+
+		// A name may have been taken since the plan, resolve it the same way
+		to, err := fs.RenameResolving(filepath.Join(log.BasePath, step.from), log.BasePath, step.to, opts.OnConflict)
+		if err != nil {
+			failCount++
+			errors = append(errors, fmt.Sprintf("Failed to rename %s to %s: %v", step.from, step.to, err))
+			continue
+		}
+		successCount++
+
+		h := &log.Items[step.index]
+		if redo {
+			h.State = design.ItemApplied
+			h.NewName = to
+			h.RestoredAs = ""
+		} else {
+			h.State = design.ItemReverted
+			h.RestoredAs = ""
+			if to != h.OriginalName {
+				h.RestoredAs = to
+			}
+		}
+		// The file keeps its identity, only the names change
+		done := *h
+		done.OriginalName, done.NewName = step.from, to
+		done.State = design.ItemApplied
+		done.RestoredAs = ""
+		entry.Items = append(entry.Items, done)
+	}
+
+	resp := &design.ExecuteResponse{
+		BatchID:      entry.ID,
+		SuccessCount: successCount,
+		FailCount:    failCount,
+		Errors:       errors,
+	}
+	if successCount > 0 {
+		if err := m.saveReplay(log, entry); err != nil {
+			return resp, fmt.Errorf("%w: %v", ErrHistoryNotSaved, err)
+		}
+	}
+	return resp, nil
+}
+
+// saveReplay saves a batch with its new item states and the undo or redo
+// entry. The batch was read before the files were renamed, so a pin set
+// since then is carried over instead of being overwritten.
+func (m *Manager) saveReplay(log, entry *design.HistoryLog) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if current, err := m.journal.get(log.ID); err == nil {
+		log.Pinned = current.Pinned
+	}
+	for _, l := range []*design.HistoryLog{log, entry} {
+		l.Status = batchStatus(l.Items)
+		if err := m.journal.put(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lineage returns the batch an entry belongs to, with every undo and redo of it.
+func (m *Manager) Lineage(batchID string) (*design.HistoryLineage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	root, err := m.journal.get(batchID)
+	if err != nil {
+		return nil, err
+	}
+	if root.ParentID != "" && m.journal.has(root.ParentID) {
+		if root, err = m.journal.get(root.ParentID); err != nil {
+			return nil, err
+		}
+	}
+	events, err := m.journal.children(root.ID)
+	if err != nil {
+		return nil, err
+	}
+	if events == nil {
+		events = []*design.HistoryLog{}
+	}
+	return &design.HistoryLineage{Batch: root, Events: events}, nil
+}
+
+// planUndo checks the selected items of a batch against the file system, the
+// same way a rename preview checks its targets. With redo the direction is
+// reversed: undone items go back to their new names.
+func planUndo(log *design.HistoryLog, redo bool, opts UndoOptions) ([]undoStep, error) {
+	if log.Kind == design.KindUndo || log.Kind == design.KindRedo {
+		return nil, ErrNotUndoable
+	}
+	policy := opts.Verify
+	if policy == "" {
+		policy = design.VerifyStrict
+	}
+
+	inBatch := make(map[string]bool, len(log.Items))
+	for _, h := range log.Items {
+		inBatch[h.NewName] = true
+	}
+	want := make(map[string]bool, len(opts.Items))
+	for _, name := range opts.Items {
+		if !inBatch[name] {
+			return nil, fmt.Errorf("%w: %s", ErrItemNotFound, name)
+		}
+		want[name] = true
+	}
+
+	steps := make([]undoStep, 0, len(log.Items))
+	seen := make(map[string]bool)
+	for i, h := range log.Items {
+		if len(want) > 0 && !want[h.NewName] {
+			continue
+		}
+		item := design.UndoPreviewItem{
+			OriginalName: h.OriginalName,
+			NewName:      h.NewName,
+			Status:       design.UndoRestorable,
+		}
+		reverted := h.State == design.ItemReverted
+		switch {
+		case reverted && !redo:
+			item.Status = design.UndoReverted
+			item.Message = fmt.Sprintf("Already restored: %s", h.OriginalName)
+		case !reverted && redo:
+			item.Status = design.UndoApplied
+			item.Message = fmt.Sprintf("Not undone: %s", h.NewName)
+		}
+		if item.Status != design.UndoRestorable {
+			steps = append(steps, undoStep{index: i, item: item})
+			continue
+		}
+
+		from, to := h.NewName, h.OriginalName
+		if redo {
+			from, to = h.OriginalName, h.NewName
+			if h.RestoredAs != "" {
+				from = h.RestoredAs
+			}
+		}
+		fromPath := filepath.Join(log.BasePath, from)
+		if _, err := os.Stat(fromPath); err != nil {
+			item.Status = design.UndoSourceMissing
+			item.Message = fmt.Sprintf("File missing: %s", from)
+		} else if policy != design.VerifyIgnore {
+			mismatches, err := verifyIdentity(h, from, fromPath)
+			if err != nil {
+				item.Status = design.UndoSourceMissing
+				item.Message = fmt.Sprintf("Cannot read %s: %v", from, err)
+			}
+			applyMismatches(&item, mismatches, policy)
+		}
+
+		if item.Status == design.UndoRestorable {
+			taken := ""
+			if seen[to] {
+				taken = fmt.Sprintf("Name %s is restored by another item of this batch", to)
+			} else if _, err := os.Lstat(filepath.Join(log.BasePath, to)); err == nil {
+				taken = fmt.Sprintf("Name is taken: %s", to)
+			}
+			if taken != "" {
+				item.Status = design.UndoTargetTaken
+				item.Message = taken
+				if opts.OnConflict == design.ConflictSuffix {
+					if free, err := fs.FreeName(log.BasePath, to, func(n string) bool { return seen[n] }); err == nil {
+						item.Status = design.UndoRestorable
+						item.Message = fmt.Sprintf("%s, using %s", taken, free)
+						item.RestoreAs = free
+						to = free
+					}
+				}
+			}
+		}
+		if item.Status == design.UndoRestorable {
+			seen[to] = true
+		}
+		steps = append(steps, undoStep{index: i, from: from, to: to, item: item})
+	}
+	return steps, nil
+}
+
+// batchStatus derives the status of a batch from the states of its items.
+func batchStatus(items []design.HistoryItem) string {
+	reverted := 0
+	for _, item := range items {
+		if item.State == design.ItemReverted {
+			reverted++
+		}
+	}
+	switch {
+	case reverted == 0:
+		return design.BatchApplied
+	case reverted == len(items):
+		return design.BatchReverted
+	}
+	return design.BatchPartiallyReverted
+}
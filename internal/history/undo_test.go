@@ -0,0 +1,344 @@
+package history
+
+import (
+	"errors"
+	"nas-renamer/design"
+	"nas-renamer/internal/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// renamedBatch creates the files of renames, given as from, to pairs, renames
+// them and saves the batch with their identities as execute would.
+func renamedBatch(t *testing.T, m *Manager, dir, id string, renames ...string) {
+	t.Helper()
+	log := &design.HistoryLog{ID: id, Timestamp: 1, BasePath: dir, Kind: design.KindExecute}
+	for i := 0; i+1 < len(renames); i += 2 {
+		from, to := filepath.Join(dir, renames[i]), filepath.Join(dir, renames[i+1])
+		if err := os.WriteFile(from, []byte("content of "+renames[i]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fid, err := fs.Identify(from, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(from, to); err != nil {
+			t.Fatal(err)
+		}
+		log.Items = append(log.Items, design.HistoryItem{
+			OriginalName: renames[i],
+			NewName:      renames[i+1],
+			Size:         fid.Size,
+			State:        design.ItemApplied,
+			ModTime:      fid.ModTime,
+			Dev:          fid.Dev,
+			Inode:        fid.Inode,
+			Hash:         fid.Hash,
+		})
+	}
+	if err := m.SaveHistory(log); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUndoVerification(t *testing.T) {
+	// Each change keeps the other recorded fields, so only its own check fails
+	changes := []struct {
+		mismatch string
+		status   string
+		change   func(path string, mtime time.Time) error
+	}{
+		{"size", design.UndoSizeChanged, func(path string, mtime time.Time) error {
+			if err := os.WriteFile(path, []byte("content of a.txt, edited"), 0644); err != nil {
+				return err
+			}
+			return os.Chtimes(path, mtime, mtime)
+		}},
+		{"mtime", design.UndoModified, func(path string, mtime time.Time) error {
+			later := mtime.Add(time.Hour)
+			return os.Chtimes(path, later, later)
+		}},
+		{"inode", design.UndoReplaced, func(path string, mtime time.Time) error {
+			// A copy with the same content and times, moved over the original
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(path+".copy", data, 0644); err != nil {
+				return err
+			}
+			if err := os.Chtimes(path+".copy", mtime, mtime); err != nil {
+				return err
+			}
+			return os.Rename(path+".copy", path)
+		}},
+		{"hash", design.UndoHashChanged, func(path string, mtime time.Time) error {
+			if err := os.WriteFile(path, []byte("CONTENT OF A.TXT"), 0644); err != nil {
+				return err
+			}
+			return os.Chtimes(path, mtime, mtime)
+		}},
+	}
+
+	for _, c := range changes {
+		for _, policy := range []string{design.VerifyStrict, design.VerifyWarn, design.VerifyIgnore} {
+			t.Run(c.mismatch+"/"+policy, func(t *testing.T) {
+				m, err := openManager(t.TempDir())
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer m.Close()
+				dir := t.TempDir()
+				renamedBatch(t, m, dir, "batch", "a.txt", "A.txt")
+
+				path := filepath.Join(dir, "A.txt")
+				log, _ := m.GetLog("batch")
+				if err := c.change(path, time.Unix(0, log.Items[0].ModTime)); err != nil {
+					t.Fatal(err)
+				}
+
+				opts := UndoOptions{Verify: policy}
+				preview, err := m.PreviewUndo("batch", opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				item := preview.Items[0]
+				switch policy {
+				case design.VerifyStrict:
+					if item.Status != c.status || !slices.Contains(item.Mismatches, c.mismatch) {
+						t.Fatalf("Expected %s with a %s mismatch, got %+v", c.status, c.mismatch, item)
+					}
+				case design.VerifyWarn:
+					if item.Status != design.UndoRestorable || !slices.Contains(item.Mismatches, c.mismatch) {
+						t.Fatalf("Expected restorable with a %s warning, got %+v", c.mismatch, item)
+					}
+				case design.VerifyIgnore:
+					if item.Status != design.UndoRestorable || len(item.Mismatches) != 0 {
+						t.Fatalf("Expected restorable without checks, got %+v", item)
+					}
+				}
+
+				resp, err := m.Undo("batch", opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, statErr := os.Stat(path)
+				if policy == design.VerifyStrict {
+					// Strict undo refuses to rename a file that is not the recorded one
+					if resp.SuccessCount != 0 || resp.FailCount != 1 || statErr != nil {
+						t.Errorf("Expected strict undo to leave A.txt alone, got %+v", resp)
+					}
+				} else if resp.SuccessCount != 1 || statErr == nil {
+					t.Errorf("Expected A.txt restored to a.txt, got %+v", resp)
+				}
+			})
+		}
+	}
+}
+
+func TestPreviewUndo(t *testing.T) {
+	m, err := openManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	dir := t.TempDir()
+	renamedBatch(t, m, dir, "batch", "a.txt", "A.txt", "b.txt", "B.txt", "c.txt", "C.txt")
+
+	// a.txt is taken by a new file, B.txt is gone, C.txt can go back
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("new"), 0644)
+	os.Remove(filepath.Join(dir, "B.txt"))
+
+	preview, err := m.PreviewUndo("batch", UndoOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{design.UndoTargetTaken, design.UndoSourceMissing, design.UndoRestorable}
+	if len(preview.Items) != len(want) {
+		t.Fatalf("Expected %d items, got %+v", len(want), preview.Items)
+	}
+	for i, item := range preview.Items {
+		if item.Status != want[i] {
+			t.Errorf("%s: expected %s, got %s (%s)", item.NewName, want[i], item.Status, item.Message)
+		}
+	}
+	// A preview renames nothing
+	for _, name := range []string{"A.txt", "C.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected %s untouched: %v", name, err)
+		}
+	}
+
+	// With the suffix strategy the taken name is replaced by a free one
+	preview, err = m.PreviewUndo("batch", UndoOptions{OnConflict: design.ConflictSuffix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item := preview.Items[0]; item.Status != design.UndoRestorable || item.RestoreAs != "a (1).txt" {
+		t.Errorf("Expected A.txt restorable as a (1).txt, got %+v", item)
+	}
+}
+
+func TestPartialUndo(t *testing.T) {
+	m, err := openManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	dir := t.TempDir()
+	renamedBatch(t, m, dir, "batch", "a.txt", "A.txt", "b.txt", "B.txt", "c.txt", "C.txt")
+
+	if _, err := m.Undo("batch", UndoOptions{Items: []string{"nope.txt"}}); !errors.Is(err, ErrItemNotFound) {
+		t.Fatalf("Expected ErrItemNotFound, got %v", err)
+	}
+
+	resp, err := m.Undo("batch", UndoOptions{Items: []string{"B.txt"}})
+	if err != nil || resp.SuccessCount != 1 {
+		t.Fatalf("Undo of B.txt failed: %v %+v", err, resp)
+	}
+	for _, name := range []string{"A.txt", "b.txt", "C.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected %s: %v", name, err)
+		}
+	}
+	log, err := m.GetLog("batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{design.ItemApplied, design.ItemReverted, design.ItemApplied}
+	for i, item := range log.Items {
+		if item.State != want[i] {
+			t.Errorf("%s: expected %s, got %s", item.NewName, want[i], item.State)
+		}
+	}
+	if log.Status != design.BatchPartiallyReverted {
+		t.Errorf("Expected %s, got %s", design.BatchPartiallyReverted, log.Status)
+	}
+
+	// Undoing the rest skips the item that is back already
+	resp, err = m.Undo("batch", UndoOptions{})
+	if err != nil || resp.SuccessCount != 2 || resp.FailCount != 0 {
+		t.Fatalf("Undo of the rest failed: %v %+v", err, resp)
+	}
+	if log, _ := m.GetLog("batch"); log.Status != design.BatchReverted {
+		t.Errorf("Expected %s, got %s", design.BatchReverted, log.Status)
+	}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected %s back: %v", name, err)
+		}
+	}
+}
+
+func TestUndoRedoRoundTrip(t *testing.T) {
+	m, err := openManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	dir := t.TempDir()
+	renamedBatch(t, m, dir, "batch", "a.txt", "A.txt")
+
+	steps := []struct {
+		redo bool
+		name string // The file name after the step
+	}{
+		{false, "a.txt"},
+		{true, "A.txt"},
+		{false, "a.txt"},
+	}
+	for _, s := range steps {
+		replay := m.Undo
+		if s.redo {
+			replay = m.Redo
+		}
+		resp, err := replay("batch", UndoOptions{})
+		if err != nil || resp.SuccessCount != 1 {
+			t.Fatalf("Expected one rename, got %v %+v", err, resp)
+		}
+		entries, _ := os.ReadDir(dir)
+		if len(entries) != 1 || entries[0].Name() != s.name {
+			t.Fatalf("Expected only %s, got %v", s.name, entries)
+		}
+	}
+
+	lineage, err := m.Lineage("batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ kind, from, to string }{
+		{design.KindUndo, "A.txt", "a.txt"},
+		{design.KindRedo, "a.txt", "A.txt"},
+		{design.KindUndo, "A.txt", "a.txt"},
+	}
+	if len(lineage.Events) != len(want) {
+		t.Fatalf("Expected %d events, got %d", len(want), len(lineage.Events))
+	}
+	for i, e := range lineage.Events {
+		w := want[i]
+		if e.Kind != w.kind || e.ParentID != "batch" || len(e.Items) != 1 {
+			t.Fatalf("Event %d: expected one %s of batch, got %+v", i, w.kind, e)
+		}
+		if item := e.Items[0]; item.OriginalName != w.from || item.NewName != w.to {
+			t.Errorf("Event %d: expected %s to %s, got %s to %s", i, w.from, w.to, item.OriginalName, item.NewName)
+		}
+	}
+	if lineage.Batch.Status != design.BatchReverted {
+		t.Errorf("Expected the batch %s, got %s", design.BatchReverted, lineage.Batch.Status)
+	}
+
+	// Events are replayed through their batch only
+	event := lineage.Events[0].ID
+	if _, err := m.Undo(event, UndoOptions{}); !errors.Is(err, ErrNotUndoable) {
+		t.Errorf("Expected ErrNotUndoable for undo, got %v", err)
+	}
+	if _, err := m.Redo(event, UndoOptions{}); !errors.Is(err, ErrNotUndoable) {
+		t.Errorf("Expected ErrNotUndoable for redo, got %v", err)
+	}
+	if _, err := m.PreviewUndo(event, UndoOptions{}); !errors.Is(err, ErrNotUndoable) {
+		t.Errorf("Expected ErrNotUndoable for the preview, got %v", err)
+	}
+}
+
+func TestUndoOriginalNameReused(t *testing.T) {
+	m, err := openManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	dir := t.TempDir()
+	renamedBatch(t, m, dir, "batch", "a.txt", "A.txt")
+	// Another file got the original name since
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("new"), 0644)
+
+	resp, err := m.Undo("batch", UndoOptions{})
+	if err != nil || resp.SuccessCount != 0 || resp.FailCount != 1 {
+		t.Fatalf("Expected the skip strategy to refuse, got %v %+v", err, resp)
+	}
+
+	resp, err = m.Undo("batch", UndoOptions{OnConflict: design.ConflictSuffix})
+	if err != nil || resp.SuccessCount != 1 {
+		t.Fatalf("Undo failed: %v %+v", err, resp)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "a.txt")); err != nil || string(data) != "new" {
+		t.Errorf("Expected the new a.txt untouched, got %q %v", data, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "a (1).txt")); err != nil || string(data) != "content of a.txt" {
+		t.Errorf("Expected the file restored as a (1).txt, got %q %v", data, err)
+	}
+	log, _ := m.GetLog("batch")
+	if log.Items[0].RestoredAs != "a (1).txt" {
+		t.Errorf("Expected RestoredAs a (1).txt, got %q", log.Items[0].RestoredAs)
+	}
+
+	// Redo starts from the name the file was restored as
+	if resp, err := m.Redo("batch", UndoOptions{}); err != nil || resp.SuccessCount != 1 {
+		t.Fatalf("Redo failed: %v %+v", err, resp)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "A.txt")); err != nil || string(data) != "content of a.txt" {
+		t.Errorf("Expected the file back at A.txt, got %q %v", data, err)
+	}
+}
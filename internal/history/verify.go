@@ -0,0 +1,104 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"nas-renamer/design"
+	"nas-renamer/internal/fs"
+	"strings"
+	"time"
+)
+
+// Identity mismatches found before undo. Each is wrapped in a *MismatchError.
+var (
+	ErrSizeMismatch    = errors.New("size changed")
+	ErrModTimeMismatch = errors.New("modification time changed")
+	ErrInodeMismatch   = errors.New("file was replaced")
+	ErrHashMismatch    = errors.New("content changed")
+)
+
+// MismatchError reports that the file at Name is not the one recorded in history.
+type MismatchError struct {
+	Name string
+	Err  error // One of the Err*Mismatch values
+	Want string
+	Got  string
+}
+
+func (e *MismatchError) Error() string {
+	return fmt.Sprintf("%s: %v (recorded %s, now %s)", e.Name, e.Err, e.Want, e.Got)
+}
+
+func (e *MismatchError) Unwrap() error { return e.Err }
+
+// mismatchKinds maps mismatch errors to the names and undo statuses they report as.
+var mismatchKinds = []struct {
+	err    error
+	name   string
+	status string
+}{
+	{ErrSizeMismatch, "size", design.UndoSizeChanged},
+	{ErrModTimeMismatch, "mtime", design.UndoModified},
+	{ErrInodeMismatch, "inode", design.UndoReplaced},
+	{ErrHashMismatch, "hash", design.UndoHashChanged},
+}
+
+// verifyIdentity compares the file at path with what history recorded about it.
+// Fields that were not recorded are not checked.
+func verifyIdentity(h design.HistoryItem, name, path string) ([]*MismatchError, error) {
+	id, err := fs.Identify(path, h.Hash != "")
+	if err != nil {
+		return nil, err
+	}
+	var mismatches []*MismatchError
+	add := func(err error, want, got string) {
+		mismatches = append(mismatches, &MismatchError{Name: name, Err: err, Want: want, Got: got})
+	}
+	if id.Size != h.Size {
+		add(ErrSizeMismatch, fmt.Sprintf("%d bytes", h.Size), fmt.Sprintf("%d bytes", id.Size))
+	}
+	if h.ModTime != 0 && id.ModTime != h.ModTime {
+		add(ErrModTimeMismatch, formatNanos(h.ModTime), formatNanos(id.ModTime))
+	}
+	if h.Inode != 0 && id.Inode != 0 && (id.Dev != h.Dev || id.Inode != h.Inode) {
+		add(ErrInodeMismatch, fmt.Sprintf("%d:%d", h.Dev, h.Inode), fmt.Sprintf("%d:%d", id.Dev, id.Inode))
+	}
+	if h.Hash != "" && id.Hash != h.Hash {
+		add(ErrHashMismatch, shortHash(h.Hash), shortHash(id.Hash))
+	}
+	return mismatches, nil
+}
+
+// applyMismatches records mismatches on a planned item according to policy.
+func applyMismatches(item *design.UndoPreviewItem, mismatches []*MismatchError, policy string) {
+	if len(mismatches) == 0 {
+		return
+	}
+	var msgs []string
+	for _, m := range mismatches {
+		for _, kind := range mismatchKinds {
+			if errors.Is(m, kind.err) {
+				item.Mismatches = append(item.Mismatches, kind.name)
+				if policy == design.VerifyStrict && item.Status == design.UndoRestorable {
+					item.Status = kind.status
+				}
+			}
+		}
+		msgs = append(msgs, m.Error())
+	}
+	item.Message = strings.Join(msgs, "; ")
+	if policy != design.VerifyStrict {
+		item.Message = "Warning: " + item.Message
+	}
+}
+
+func formatNanos(ns int64) string {
+	return time.Unix(0, ns).UTC().Format(time.RFC3339)
+}
+
+func shortHash(h string) string {
+	if len(h) > 12 {
+		return h[:12]
+	}
+	return h
+}
@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"nas-renamer/design"
 	"net/http"
 	"strings"
@@ -11,6 +13,15 @@ import (
 	"github.com/google/uuid"
 )
 
+// SessionKey is the context key of the session ID of an authenticated request.
+const SessionKey = "session"
+
+// SessionID identifies the session of token in logs without revealing the token.
+func SessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
 // SessionStore is a simple in-memory session manager.
 type SessionStore struct {
 	sessions map[string]design.Session
@@ -70,6 +81,7 @@ func AuthMiddleware() gin.HandlerFunc {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			return
 		}
+		c.Set(SessionKey, SessionID(token))
 
 		c.Next()
 	}
@@ -0,0 +1,140 @@
+package renamer
+
+import (
+	"fmt"
+	"nas-renamer/design"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+const benchFiles = 100_000
+
+var benchTemplates = []string{
+	"[SunMovie]Show.Name.S01E%03d.1080p.WEB-DL.x265-GRP.mkv",
+	"www.example.com_Movie_%d_(2009)【高清】.mp4",
+	"[SubsPlease] Anime - %d [1080p][A1B2C3D4].mkv",
+	"IMG_2023%05d.jpg",
+	"更多资源请访问 xxx.net 电影%d.国语中字.mkv",
+}
+
+func benchNames(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf(benchTemplates[i%len(benchTemplates)], i)
+	}
+	return names
+}
+
+var benchLexicon = &fakeLexicon{
+	junk: []design.JunkToken{
+		{Pattern: "SunMovie", Enabled: true},
+		{Pattern: "电影天堂", Enabled: true},
+		{Pattern: `\d{3}ys\.com`, Regex: true, Enabled: true},
+	},
+	scene: map[string][]string{
+		design.SceneTagResolution: {"1080p", "720p", "2160p", "4K"},
+		design.SceneTagSource:     {"WEB-DL", "WEBRip", "BluRay"},
+		design.SceneTagCodec:      {"x264", "x265", "HEVC", "AAC"},
+		design.SceneTagChinese:    {"国语中字", "高清"},
+	},
+	tlds: []string{"com", "net", "org", "cn", "cc", "me", "tv", "top"},
+}
+
+var benchQuick = design.QuickOptions{
+	RemoveKnownJunk:   true,
+	RemoveURL:         true,
+	RemoveResolution:  true,
+	RemoveSource:      true,
+	RemoveCodec:       true,
+	RemoveChineseTags: true,
+	SmartBrackets:     true,
+	BracketCategories: []string{design.BracketGroup, design.BracketResolution, design.BracketCRC, design.BracketAd},
+	NormalizeDelim:    true,
+	ProtectExtension:  true,
+}
+
+// BenchmarkQuickPipeline measures rule evaluation alone, without any file system access.
+func BenchmarkQuickPipeline(b *testing.B) {
+	names := benchNames(benchFiles)
+	p, err := NewEngineWithLexicon(benchLexicon).compileQuickRules(benchQuick)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, name := range names {
+			p.apply(name)
+		}
+	}
+	b.ReportMetric(float64(benchFiles*b.N)/b.Elapsed().Seconds(), "names/s")
+}
+
+func BenchmarkCustomPipeline(b *testing.B) {
+	names := benchNames(benchFiles)
+	p := compileCustomRules([]design.RenameRule{
+		{Type: design.RuleRegex, Target: `\[.*?\]`, Replacement: ""},
+		{Type: design.RuleReplace, Target: "_", Replacement: "."},
+		{Type: design.RuleRemoveWords, Count: 1, FromEnd: true},
+		{Type: design.RuleInsert, Target: "-", Start: 3},
+	})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, name := range names {
+			p.apply(name, nil)
+		}
+	}
+	b.ReportMetric(float64(benchFiles*b.N)/b.Elapsed().Seconds(), "names/s")
+}
+
+var (
+	benchDirOnce sync.Once
+	benchDir     string
+)
+
+// benchDirectory creates benchFiles empty files once per test binary run.
+func benchDirectory(b *testing.B) string {
+	benchDirOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "renamer-bench")
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, name := range benchNames(benchFiles) {
+			f, err := os.Create(filepath.Join(dir, name))
+			if err != nil {
+				b.Fatal(err)
+			}
+			f.Close()
+		}
+		benchDir = dir
+	})
+	return benchDir
+}
+
+// BenchmarkComputePreview measures a full preview of a 100k file directory,
+// including the directory listing and the conflict checks on disk.
+func BenchmarkComputePreview(b *testing.B) {
+	dir := benchDirectory(b)
+	engine := NewEngineWithLexicon(benchLexicon)
+	req := &design.RenameRequest{DirPath: dir, Mode: design.ModeQuick, QuickRules: benchQuick}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		preview, err := engine.ComputePreview(req, []string{".nfo"})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(preview.Items) != benchFiles {
+			b.Fatalf("Expected %d items, got %d", benchFiles, len(preview.Items))
+		}
+	}
+	b.ReportMetric(float64(benchFiles*b.N)/b.Elapsed().Seconds(), "files/s")
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if benchDir != "" {
+		os.RemoveAll(benchDir)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,69 @@
+package renamer
+
+import (
+	"fmt"
+	"nas-renamer/design"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	bracketRe        = regexp.MustCompile(`\[[^\[\]]*\]|【[^【】]*】|\([^()]*\)|（[^（）]*）`)
+	bracketYearRe    = regexp.MustCompile(`^(?:19|20)\d{2}$`)
+	bracketEpisodeRe = regexp.MustCompile(`(?i)^(?:(?:EP?|第)?\d{1,4}(?:v\d)?(?:集|话|話)?|S\d{1,2}E\d{1,4}|\d{1,4}-\d{1,4})$`)
+	bracketCRCRe     = regexp.MustCompile(`^[0-9A-Fa-f]{8}$`)
+	bracketResRe     = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(?:\d{3,4}[pi]|[248]K|\d{3,4}x\d{3,4}|x26[45]|HEVC|AVC|10bit|UHD)(?:[^a-z0-9]|$)`)
+	bracketLangRe    = regexp.MustCompile(`(?i)(?:^|[^a-z])(?:CHS|CHT|GB|BIG5|JP|JPN|ENG|SC|TC)(?:[^a-z]|$)|简|繁|中文|中字|字幕|双语|雙語|日语|英语|国语|粤语`)
+	bracketURLRe     = regexp.MustCompile(`(?i)www\.|https?://`)
+)
+
+// classifyBracket returns the Bracket* category of a bracket's content.
+// leading is true for a bracket at the very start of the name.
+func (p *quickPipeline) classifyBracket(content string, leading bool) string {
+	inner := strings.TrimSpace(content)
+	if p.isJunk(inner) {
+		return design.BracketAd
+	}
+	switch {
+	case p.domains.isAd(inner):
+		return design.BracketAd
+	case bracketYearRe.MatchString(inner):
+		return design.BracketYear
+	case bracketEpisodeRe.MatchString(inner):
+		return design.BracketEpisode
+	case bracketCRCRe.MatchString(inner) && strings.ContainsAny(inner, "0123456789"):
+		return design.BracketCRC
+	case bracketResRe.MatchString(inner):
+		return design.BracketResolution
+	case bracketLangRe.MatchString(inner):
+		return design.BracketLanguage
+	case leading:
+		return design.BracketGroup
+	}
+	return design.BracketOther
+}
+
+// removeSmartBrackets drops brackets whose category is selected and notes why.
+func (p *quickPipeline) removeSmartBrackets(base string, notes *[]string) string {
+	var sb strings.Builder
+	last := 0
+	for _, loc := range bracketRe.FindAllStringIndex(base, -1) {
+		m := base[loc[0]:loc[1]]
+		// Bracket characters are a single rune on each side
+		runes := []rune(m)
+		leading := strings.TrimSpace(base[:loc[0]]) == ""
+		category := p.classifyBracket(string(runes[1:len(runes)-1]), leading)
+
+		if !slices.Contains(p.rules.BracketCategories, category) {
+			continue
+		}
+		sb.WriteString(base[last:loc[0]])
+		last = loc[1]
+		if notes != nil {
+			*notes = append(*notes, fmt.Sprintf("Removed %q (%s)", m, category))
+		}
+	}
+	sb.WriteString(base[last:])
+	return sb.String()
+}
@@ -0,0 +1,117 @@
+package renamer
+
+import (
+	"fmt"
+	"nas-renamer/design"
+	"nas-renamer/internal/script"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// customPipeline is a list of custom rules with regexes and scripts compiled once.
+type customPipeline struct {
+	rules   []design.RenameRule
+	regexes map[int]*regexp.Regexp
+	scripts map[int]*script.Program
+	errs    map[int]error // Compile errors of script rules, reported per item
+}
+
+func compileCustomRules(rules []design.RenameRule) *customPipeline {
+	p := &customPipeline{
+		rules:   rules,
+		regexes: make(map[int]*regexp.Regexp),
+		scripts: make(map[int]*script.Program),
+		errs:    make(map[int]error),
+	}
+	for i, rule := range rules {
+		switch rule.Type {
+		case design.RuleRegex:
+			// Invalid regexes are skipped silently, as before
+			if re, err := regexp.Compile(rule.Target); err == nil {
+				p.regexes[i] = re
+			}
+		case design.RuleScript:
+			prog, err := script.Compile(rule.Target)
+			if err != nil {
+				p.errs[i] = err
+			} else {
+				p.scripts[i] = prog
+			}
+		}
+	}
+	return p
+}
+
+// needsStat reports whether rules read file stats, which costs a syscall per file.
+func (p *customPipeline) needsStat() bool {
+	return len(p.scripts) > 0
+}
+
+// apply runs all rules on name. info may be nil when no rule needs it.
+func (p *customPipeline) apply(name string, info os.FileInfo) (string, error) {
+	return p.run(name, info, nil)
+}
+
+// run is apply, recording the name after each rule to t, which may be nil.
+func (p *customPipeline) run(name string, info os.FileInfo, t *tracer) (string, error) {
+	res := name
+	for i, rule := range p.rules {
+		switch rule.Type {
+		case design.RuleReplace:
+			res = strings.ReplaceAll(res, rule.Target, rule.Replacement)
+		case design.RuleRegex:
+			if re := p.regexes[i]; re != nil {
+				res = re.ReplaceAllString(res, rule.Replacement)
+			}
+		case design.RulePrefix:
+			res = rule.Target + res
+		case design.RuleSuffix:
+			ext := filepath.Ext(res)
+			base := strings.TrimSuffix(res, ext)
+			res = base + rule.Target + ext
+		case design.RuleInsert, design.RuleDelete, design.RuleKeep, design.RuleRemoveWords:
+			res = applyPositional(res, rule)
+		case design.RuleScript:
+			if err := p.errs[i]; err != nil {
+				return name, fmt.Errorf("rule %d (script): %w", i+1, err)
+			}
+			out, err := p.scripts[i].RunString(scriptVars(res, info), script.DefaultLimits)
+			if err != nil {
+				return name, fmt.Errorf("rule %d (script): %w", i+1, err)
+			}
+			if strings.ContainsAny(out, `/\`) {
+				return name, fmt.Errorf("rule %d (script): result contains a path separator", i+1)
+			}
+			res = out
+		}
+		t.record(rule.Type, i, res)
+	}
+	return res, nil
+}
+
+// scriptVars builds the variables visible to a script.
+func scriptVars(name string, info os.FileInfo) map[string]any {
+	ext := filepath.Ext(name)
+	f := parseFields(name)
+	vars := map[string]any{
+		"name": name,
+		"base": strings.TrimSuffix(name, ext),
+		"ext":  ext,
+		"fields": map[string]any{
+			"title":      f.Title,
+			"year":       f.Year,
+			"season":     f.Season,
+			"episode":    f.Episode,
+			"resolution": f.Resolution,
+		},
+		"size":  float64(0),
+		"mtime": float64(0),
+	}
+	if info != nil {
+		vars["size"] = float64(info.Size())
+		vars["mtime"] = float64(info.ModTime().Unix())
+	}
+	return vars
+}
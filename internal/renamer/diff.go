@@ -0,0 +1,99 @@
+package renamer
+
+import (
+	"nas-renamer/design"
+)
+
+// maxDiffCells bounds the LCS table. Longer pairs are reported as a whole
+// deletion and insertion of their middle part.
+const maxDiffCells = 1 << 20
+
+// diffNames returns the rune level edit from a to b as keep, delete and insert
+// spans. Within a changed region deletions come before insertions.
+func diffNames(a, b string) []design.DiffSpan {
+	if a == b {
+		return nil
+	}
+	ar, br := []rune(a), []rune(b)
+
+	// Names usually share a long prefix and suffix, keep the table small
+	prefix := 0
+	for prefix < len(ar) && prefix < len(br) && ar[prefix] == br[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(ar)-prefix && suffix < len(br)-prefix && ar[len(ar)-1-suffix] == br[len(br)-1-suffix] {
+		suffix++
+	}
+
+	d := &differ{a: ar, b: br}
+	d.add(design.DiffKeep, 0, 0, prefix)
+	d.middle(ar[prefix:len(ar)-suffix], br[prefix:len(br)-suffix], prefix, prefix)
+	d.add(design.DiffKeep, len(ar)-suffix, len(br)-suffix, suffix)
+	return d.spans
+}
+
+type differ struct {
+	a, b  []rune
+	spans []design.DiffSpan
+}
+
+// middle diffs a against b, which start at rune oi of the original and ni of the new name.
+func (d *differ) middle(a, b []rune, oi, ni int) {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 || n*m > maxDiffCells {
+		d.add(design.DiffDelete, oi, ni, n)
+		d.add(design.DiffInsert, oi+n, ni, m)
+		return
+	}
+
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && a[i] == b[j]:
+			d.add(design.DiffKeep, oi+i, ni+j, 1)
+			i++
+			j++
+		case j == m || (i < n && lcs[i+1][j] >= lcs[i][j+1]):
+			d.add(design.DiffDelete, oi+i, ni+j, 1)
+			i++
+		default:
+			d.add(design.DiffInsert, oi+i, ni+j, 1)
+			j++
+		}
+	}
+}
+
+// add appends count runes starting at oi in the original name (ni in the new
+// one for insertions), merging with the previous span of the same operation.
+func (d *differ) add(op string, oi, ni, count int) {
+	if count == 0 {
+		return
+	}
+	var text string
+	if op == design.DiffInsert {
+		text = string(d.b[ni : ni+count])
+	} else {
+		text = string(d.a[oi : oi+count])
+	}
+	if last := len(d.spans) - 1; last >= 0 && d.spans[last].Op == op {
+		d.spans[last].Text += text
+		return
+	}
+	d.spans = append(d.spans, design.DiffSpan{Op: op, Text: text, Old: oi, New: ni})
+}
@@ -3,105 +3,83 @@ package renamer
 import (
 	"fmt"
 	"nas-renamer/design"
+	"nas-renamer/internal/fs"
 	"os"
 	"path/filepath"
-	"regexp"
-	"strings"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// Lexicon supplies the user editable word lists used by quick mode.
+// config.Manager implements it.
+type Lexicon interface {
+	GetJunkTokens() ([]design.JunkToken, error)
+	GetSceneTags() (map[string][]string, error)
+	GetAdTLDs() ([]string, error)
+}
+
 // Engine handles renaming logic.
-type Engine struct{}
+type Engine struct {
+	lexicon Lexicon // Optional, quick mode options that need it are no-ops without
+}
 
 func NewEngine() *Engine {
 	return &Engine{}
 }
 
+// NewEngineWithLexicon returns an engine that reads word lists from lex.
+func NewEngineWithLexicon(lex Lexicon) *Engine {
+	return &Engine{lexicon: lex}
+}
+
 // ComputePreview calculates the potential changes without modifying files.
 func (e *Engine) ComputePreview(req *design.RenameRequest, ignoredExts []string) (*design.PreviewResponse, error) {
-	// 1. Identify target files
-	targets, err := e.identifyTargets(req)
+	var items []design.PreviewItem
+	stats, err := e.PreviewEach(req, ignoredExts, func(item design.PreviewItem) error {
+		items = append(items, item)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	var items []design.PreviewItem
-	seenNewNames := make(map[string]bool)
-
-	for _, path := range targets {
-		originalName := filepath.Base(path)
-
-		// Check ignored extensions
-		ext := strings.ToLower(filepath.Ext(originalName))
-		isIgnored := false
-		for _, ignored := range ignoredExts {
-			if strings.EqualFold(ext, ignored) || strings.EqualFold(ext, "."+ignored) || strings.EqualFold(originalName, ignored) {
-				isIgnored = true
-				break
-			}
-		}
-
-		if isIgnored {
-			// Skip entirely? Or show as skipped?
-			// Guide says: "visually grayed out or excluded".
-			// Let's add as "skipped" status.
-			items = append(items, design.PreviewItem{
-				OriginalName: originalName,
-				NewName:      originalName,
-				Status:       "skipped",
-				Message:      "Ignored extension",
-			})
-			continue
-		}
-
-		newName := originalName
-
-		// 2. Apply rules based on mode
-		if req.Mode == design.ModeQuick {
-			newName = e.applyQuickRules(originalName, req.QuickRules)
-		} else {
-			newName = e.applyCustomRules(originalName, req.CustomRules)
-		}
-
-		status := "ok"
-		message := ""
-
-		// 3. Check for conflicts
-		// a. Check against other new names in this batch
-		if seenNewNames[newName] && newName != originalName {
-			status = "conflict"
-			message = "New name conflicts with another file in this batch"
-		}
-		seenNewNames[newName] = true
-
-		// b. Check against file system (unless it's the same file)
-		if newName != originalName {
-			newPath := filepath.Join(filepath.Dir(path), newName)
-			if _, err := os.Stat(newPath); err == nil {
-				status = "conflict"
-				message = "Target filename already exists"
-			}
-		} else {
-			status = "ok" // No change
-		}
+	return &design.PreviewResponse{Items: items, RuleStats: stats}, nil
+}
 
-		items = append(items, design.PreviewItem{
-			OriginalName: originalName,
-			NewName:      newName,
-			Status:       status,
-			Message:      message,
-		})
-	}
+// IntentLog records a batch while it runs, so that one cut short by a crash
+// can be completed or rolled back. history.Manager implements it.
+type IntentLog interface {
+	// Begin records the batch and its planned renames before the first one.
+	Begin(log *design.HistoryLog, planned []design.HistoryItem) error
+	// Intend records that planned item index is about to be renamed to
+	// item.NewName, and returns once that is on disk.
+	Intend(batchID string, index int, item design.HistoryItem) error
+	// Done records that planned item index was renamed to name.
+	Done(batchID string, index int, name string) error
+}
 
-	return &design.PreviewResponse{Items: items}, nil
+// ExecuteOptions are server side settings of ExecuteRename.
+type ExecuteOptions struct {
+	IgnoredExts []string
+	HashFiles   bool      // Record a partial content hash of every renamed file
+	Intents     IntentLog // Optional, the batch is not recoverable without
 }
 
 // ExecuteRename performs the actual renaming.
-func (e *Engine) ExecuteRename(req *design.RenameRequest, ignoredExts []string) (*design.ExecuteResponse, *design.HistoryLog, error) {
+// Batches on the same directory are serialized: a second one fails with
+// fs.ErrDirectoryBusy while the first runs. With opts.Intents every rename is
+// logged before it happens; the caller ends the batch once history is saved.
+func (e *Engine) ExecuteRename(req *design.RenameRequest, opts ExecuteOptions) (*design.ExecuteResponse, *design.HistoryLog, error) {
+	unlock, err := fs.LockDir(req.DirPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
 	// Re-calculate to ensure consistency (or we could pass the preview result if state was guaranteed)
-	preview, err := e.ComputePreview(req, ignoredExts)
+	preview, err := e.ComputePreview(req, opts.IgnoredExts)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -113,39 +91,104 @@ func (e *Engine) ExecuteRename(req *design.RenameRequest, ignoredExts []string)
 	var historyItems []design.HistoryItem
 	timestamp := time.Now().Unix()
 
+	historyLog := &design.HistoryLog{
+		ID:        batchID,
+		Timestamp: timestamp,
+		BasePath:  req.DirPath,
+		Mode:      req.Mode,
+		Kind:      design.KindExecute,
+	}
+
+	var planned []design.HistoryItem
+	blocked := 0
 	for _, item := range preview.Items {
 		if item.Status != "ok" {
 			failCount++
-			if item.Status == "conflict" {
+			if item.Status == "conflict" || item.Status == "error" {
+				blocked++
 				errors = append(errors, fmt.Sprintf("%s: %s", item.OriginalName, item.Message))
 			}
 			continue
 		}
-
 		if item.NewName == item.OriginalName {
 			continue
 		}
+		planned = append(planned, design.HistoryItem{OriginalName: item.OriginalName, NewName: item.NewName})
+	}
+
+	// An atomic batch that cannot succeed is not started
+	if req.Atomic && blocked > 0 {
+		errors = append(errors, fmt.Sprintf("Atomic batch not started: %d files cannot be renamed", blocked))
+		return &design.ExecuteResponse{
+			BatchID:   batchID,
+			FailCount: failCount,
+			Errors:    errors,
+		}, historyLog, nil
+	}
 
+	intents := opts.Intents
+	if intents != nil && len(planned) > 0 {
+		if err := intents.Begin(historyLog, planned); err != nil {
+			return nil, nil, fmt.Errorf("cannot write intent log, nothing was renamed: %w", err)
+		}
+	}
+
+	stopped := false
+	for i, item := range planned {
 		oldPath := filepath.Join(req.DirPath, item.OriginalName)
-		newPath := filepath.Join(req.DirPath, item.NewName)
 
-		// Get file size for history safety check
-		info, err := os.Stat(oldPath)
-		var size int64
-		if err == nil {
-			size = info.Size()
+		// Record what the file is, so undo can tell if it was replaced since
+		id, _ := fs.Identify(oldPath, opts.HashFiles)
+		done := design.HistoryItem{
+			OriginalName: item.OriginalName,
+			NewName:      item.NewName,
+			Size:         id.Size,
+			State:        design.ItemApplied,
+			ModTime:      id.ModTime,
+			Dev:          id.Dev,
+			Inode:        id.Inode,
+			Hash:         id.Hash,
 		}
 
-		if err := os.Rename(oldPath, newPath); err != nil {
+		// Log the exact target, so recovery finds a suffixed name too
+		target, err := fs.TargetName(oldPath, req.DirPath, item.NewName, req.OnConflict)
+		if err != nil {
 			failCount++
 			errors = append(errors, fmt.Sprintf("Failed to rename %s: %v", item.OriginalName, err))
-		} else {
-			successCount++
-			historyItems = append(historyItems, design.HistoryItem{
-				OriginalName: item.OriginalName,
-				NewName:      item.NewName,
-				Size:         size,
-			})
+			if req.Atomic {
+				stopped = true
+				break
+			}
+			continue
+		}
+		done.NewName = target
+
+		// A rename that is not in the intent log could not be recovered
+		if intents != nil {
+			if err := intents.Intend(batchID, i, done); err != nil {
+				failCount += len(planned) - i
+				errors = append(errors, fmt.Sprintf("Stopped before %s, cannot write intent log: %v", item.OriginalName, err))
+				stopped = true
+				break
+			}
+		}
+
+		// Never replace a file that appeared after the preview
+		newName, err := fs.RenameResolving(oldPath, req.DirPath, target, req.OnConflict)
+		if err != nil {
+			failCount++
+			errors = append(errors, fmt.Sprintf("Failed to rename %s: %v", item.OriginalName, err))
+			if req.Atomic {
+				stopped = true
+				break
+			}
+			continue
+		}
+		successCount++
+		done.NewName = newName
+		historyItems = append(historyItems, done)
+		if intents != nil {
+			_ = intents.Done(batchID, i, newName) // Recovery checks the files without it
 		}
 	}
 
@@ -155,23 +198,44 @@ func (e *Engine) ExecuteRename(req *design.RenameRequest, ignoredExts []string)
 		FailCount:    failCount,
 		Errors:       errors,
 	}
-
-	historyLog := &design.HistoryLog{
-		ID:        batchID,
-		Timestamp: timestamp,
-		BasePath:  req.DirPath,
-		Mode:      req.Mode,
-		Items:     historyItems,
+	if req.Atomic && stopped {
+		historyItems = rollback(req.DirPath, historyItems, executeResp)
 	}
+	historyLog.Items = historyItems
 
 	return executeResp, historyLog, nil
 }
 
+// rollback renames done items back, newest first, for an atomic batch that
+// failed. It returns the items that could not be renamed back, which stay in
+// the history so they can still be undone.
+func rollback(dir string, done []design.HistoryItem, resp *design.ExecuteResponse) []design.HistoryItem {
+	var kept []design.HistoryItem
+	for i := len(done) - 1; i >= 0; i-- {
+		item := done[i]
+		err := fs.RenameNoReplace(filepath.Join(dir, item.NewName), filepath.Join(dir, item.OriginalName))
+		if err != nil {
+			resp.RollbackErrors = append(resp.RollbackErrors, fmt.Sprintf("Failed to rename %s back to %s: %v", item.NewName, item.OriginalName, err))
+			kept = append(kept, item)
+		}
+	}
+	slices.Reverse(kept)
+	resp.SuccessCount = len(kept)
+	resp.RolledBack = len(kept) == 0
+	return kept
+}
+
 // Internal helpers
 
 func (e *Engine) identifyTargets(req *design.RenameRequest) ([]string, error) {
 	if len(req.TargetPaths) > 0 {
-		return req.TargetPaths, nil
+		var paths []string
+		for _, path := range req.TargetPaths {
+			if matchesFilter(filepath.Base(path), req.Filters) {
+				paths = append(paths, path)
+			}
+		}
+		return paths, nil
 	}
 	// If no specific targets, list all file in dir
 	entries, err := os.ReadDir(req.DirPath)
@@ -181,7 +245,7 @@ func (e *Engine) identifyTargets(req *design.RenameRequest) ([]string, error) {
 
 	var paths []string
 	for _, entry := range entries {
-		if !entry.IsDir() {
+		if !entry.IsDir() && matchesFilter(entry.Name(), req.Filters) {
 			paths = append(paths, filepath.Join(req.DirPath, entry.Name()))
 		}
 	}
@@ -189,67 +253,11 @@ func (e *Engine) identifyTargets(req *design.RenameRequest) ([]string, error) {
 }
 
 func (e *Engine) applyQuickRules(name string, rules design.QuickOptions) string {
-	ext := filepath.Ext(name)
-	base := name
-	if rules.ProtectExtension && ext != "" {
-		base = strings.TrimSuffix(name, ext)
-	}
-
-	// 1. Remove Brackets [...] 【...】
-	if rules.RemoveBrackets {
-		re := regexp.MustCompile(`\[.*?\]|【.*?】`)
-		base = re.ReplaceAllString(base, "")
-	}
-
-	// 2. Remove Parens (...) （...）
-	if rules.RemoveParens {
-		re := regexp.MustCompile(`\(.*?\)|（.*?）`)
-		base = re.ReplaceAllString(base, "")
-	}
-
-	// 3. Remove URL (Simple heuristic)
-	if rules.RemoveURL {
-		// Matches typical domains like .com, .net, etc. at the end of words
-		// This is a simplified regex.
-		re := regexp.MustCompile(`(?i)\b[a-z0-9-]+\.(com|net|org|cn|cc|me|io|xyz)\b`)
-		base = re.ReplaceAllString(base, "")
-	}
-
-	// 4. Normalize Delimiters (_ -> .)
-	if rules.NormalizeDelim {
-		base = strings.ReplaceAll(base, "_", ".")
-	}
-
-	// Cleanup: Trim extra spaces/dots potentially left behind
-	base = strings.TrimSpace(base)
-	base = regexp.MustCompile(`\s+`).ReplaceAllString(base, " ")
-	base = regexp.MustCompile(`\.+`).ReplaceAllString(base, ".")
-	base = strings.Trim(base, ".") // Don't start/end with dot
-
-	if rules.ProtectExtension {
-		return base + ext
-	}
-	return base
+	p, _ := e.compileQuickRules(rules)
+	return p.apply(name)
 }
 
 func (e *Engine) applyCustomRules(name string, rules []design.RenameRule) string {
-	res := name
-	for _, rule := range rules {
-		switch rule.Type {
-		case "replace":
-			res = strings.ReplaceAll(res, rule.Target, rule.Replacement)
-		case "regex":
-			re, err := regexp.Compile(rule.Target)
-			if err == nil {
-				res = re.ReplaceAllString(res, rule.Replacement)
-			}
-		case "prefix":
-			res = rule.Target + res
-		case "suffix":
-			ext := filepath.Ext(res)
-			base := strings.TrimSuffix(res, ext)
-			res = base + rule.Target + ext
-		}
-	}
+	res, _ := compileCustomRules(rules).apply(name, nil)
 	return res
 }
@@ -0,0 +1,57 @@
+package renamer
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// NameFields are the parts recognised in a media file name.
+type NameFields struct {
+	Title      string
+	Year       string
+	Season     string
+	Episode    string
+	Resolution string
+}
+
+var (
+	yearRe       = regexp.MustCompile(`(?:^|[^0-9])((?:19|20)\d{2})(?:[^0-9]|$)`)
+	seasonEpRe   = regexp.MustCompile(`(?i)\bS(\d{1,2})[ ._-]?E(\d{1,4})\b`)
+	episodeRe    = regexp.MustCompile(`(?i)(?:\bEP?(\d{1,4})\b|第(\d{1,4})[集话話])`)
+	resolutionRe = regexp.MustCompile(`(?i)\b(\d{3,4}[pi]|[248]K|UHD)\b`)
+	fieldSepRe   = regexp.MustCompile(`[._\s]+`)
+)
+
+// parseFields extracts well known fields with simple heuristics.
+// Fields that are not found are left empty.
+func parseFields(name string) NameFields {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	var f NameFields
+	cut := len(base) // Title is everything before the first recognised field
+
+	if m := seasonEpRe.FindStringSubmatchIndex(base); m != nil {
+		f.Season = base[m[2]:m[3]]
+		f.Episode = base[m[4]:m[5]]
+		cut = min(cut, m[0])
+	} else if m := episodeRe.FindStringSubmatchIndex(base); m != nil {
+		if m[2] >= 0 {
+			f.Episode = base[m[2]:m[3]]
+		} else {
+			f.Episode = base[m[4]:m[5]]
+		}
+		cut = min(cut, m[0])
+	}
+	if m := yearRe.FindStringSubmatchIndex(base); m != nil && m[2] > 0 {
+		f.Year = base[m[2]:m[3]]
+		cut = min(cut, m[2])
+	}
+	if m := resolutionRe.FindStringSubmatchIndex(base); m != nil {
+		f.Resolution = base[m[2]:m[3]]
+		cut = min(cut, m[0])
+	}
+
+	title := fieldSepRe.ReplaceAllString(base[:cut], " ")
+	f.Title = strings.Trim(title, " -([【")
+	return f
+}
@@ -0,0 +1,247 @@
+package renamer
+
+import (
+	"errors"
+	"fmt"
+	"nas-renamer/design"
+	"nas-renamer/internal/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Preview work is split into chunks. Each chunk is evaluated by a bounded
+// pool of workers, then emitted in order so batch conflicts stay deterministic
+// and memory does not grow with the directory size.
+const previewChunkSize = 1024
+
+// previewWorkers bounds concurrent rule evaluation and os.Stat calls.
+// Stat is mostly I/O wait on a NAS, so use more workers than CPUs.
+var previewWorkers = max(8, 2*runtime.GOMAXPROCS(0))
+
+// plan is a RenameRequest compiled once for a whole batch.
+type plan struct {
+	req     *design.RenameRequest
+	ignored map[string]bool // Lower-cased extensions ("x" and ".x") and full names
+	quick   *quickPipeline
+	custom  *customPipeline
+}
+
+func (e *Engine) compilePlan(req *design.RenameRequest, ignoredExts []string) (*plan, error) {
+	p := &plan{
+		req:     req,
+		ignored: make(map[string]bool, 2*len(ignoredExts)),
+	}
+	for _, ig := range ignoredExts {
+		ig = strings.ToLower(ig)
+		p.ignored[ig] = true
+		p.ignored["."+ig] = true
+	}
+	if req.Mode == design.ModeQuick {
+		quick, err := e.compileQuickRules(req.QuickRules)
+		if err != nil {
+			return nil, err
+		}
+		p.quick = quick
+	} else {
+		p.custom = compileCustomRules(req.CustomRules)
+	}
+	return p, nil
+}
+
+func (p *plan) isIgnored(name string) bool {
+	if len(p.ignored) == 0 {
+		return false
+	}
+	return p.ignored[strings.ToLower(filepath.Ext(name))] || p.ignored[strings.ToLower(name)]
+}
+
+// evaluated is one target after rules ran, before batch conflicts are resolved.
+type evaluated struct {
+	item   design.PreviewItem
+	exists bool // NewName already exists on disk
+}
+
+// evaluate applies the rules to one file. It is safe to call concurrently.
+func (p *plan) evaluate(path string, index int) evaluated {
+	originalName := filepath.Base(path)
+	if p.isIgnored(originalName) {
+		return evaluated{item: design.PreviewItem{
+			OriginalName: originalName,
+			NewName:      originalName,
+			Status:       "skipped",
+			Message:      "Ignored extension",
+		}}
+	}
+
+	var t *tracer
+	if p.req.Trace {
+		t = newTracer(originalName)
+	}
+	var newName string
+	var notes []string
+	if p.quick != nil {
+		newName, notes = p.quick.explain(originalName, t)
+	} else {
+		var info os.FileInfo
+		if p.custom.needsStat() {
+			info, _ = os.Stat(path)
+		}
+		var err error
+		newName, err = p.custom.run(originalName, info, t)
+		if err != nil {
+			return evaluated{item: design.PreviewItem{
+				OriginalName: originalName,
+				NewName:      originalName,
+				Status:       "error",
+				Message:      err.Error(),
+				Trace:        t.result(),
+			}}
+		}
+	}
+	dir := filepath.Dir(path)
+	if p.req.Template != "" {
+		newName = applyTemplate(p.req.Template, newName, originalName, dir, index)
+		t.record(stepTemplate, -1, newName)
+	}
+
+	// Every rule and the template end here, so no path can leave the directory
+	if err := checkNewName(newName); err != nil {
+		return evaluated{item: design.PreviewItem{
+			OriginalName: originalName,
+			NewName:      originalName,
+			Status:       "error",
+			Message:      err.Error(),
+			Trace:        t.result(),
+		}}
+	}
+
+	ev := evaluated{item: design.PreviewItem{
+		OriginalName: originalName,
+		NewName:      newName,
+		Status:       "ok",
+		Notes:        notes,
+		Diff:         diffNames(originalName, newName),
+		Trace:        t.result(),
+	}}
+	if newName != originalName {
+		if _, err := os.Stat(filepath.Join(dir, newName)); err == nil {
+			ev.exists = true
+		}
+	}
+	return ev
+}
+
+// checkNewName rejects names that are not a plain file name in the directory.
+func checkNewName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("new name is empty")
+	case name == "." || name == "..":
+		return fmt.Errorf("new name %q is not a file name", name)
+	case strings.ContainsAny(name, `/\`):
+		return fmt.Errorf("new name %q contains a path separator", name)
+	}
+	return nil
+}
+
+// previewEach evaluates targets and calls emit for every item, in target order.
+// Returning an error from emit stops the preview.
+func (p *plan) previewEach(targets []string, emit func(design.PreviewItem) error) error {
+	// Template indexes count the files that are not ignored
+	indexes := make([]int, len(targets))
+	n := 0
+	for i, path := range targets {
+		if !p.isIgnored(filepath.Base(path)) {
+			n++
+			indexes[i] = n
+		}
+	}
+
+	seenNewNames := make(map[string]bool)
+	chunk := make([]evaluated, 0, previewChunkSize)
+	for start := 0; start < len(targets); start += previewChunkSize {
+		end := min(start+previewChunkSize, len(targets))
+		chunk = chunk[:end-start]
+		parallelFor(end-start, previewWorkers, func(i int) {
+			chunk[i] = p.evaluate(targets[start+i], indexes[start+i])
+		})
+
+		for i, ev := range chunk {
+			item := ev.item
+			if item.Status == "ok" {
+				resolveConflict(&item, ev.exists, seenNewNames)
+				if item.Status == "conflict" && p.req.OnConflict == design.ConflictSuffix {
+					p.suffixConflict(&item, filepath.Dir(targets[start+i]), seenNewNames)
+				}
+			}
+			if err := emit(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveConflict marks item as a conflict against earlier items or the disk.
+func resolveConflict(item *design.PreviewItem, exists bool, seen map[string]bool) {
+	changed := item.NewName != item.OriginalName
+	// a. Check against other new names in this batch
+	if seen[item.NewName] && changed {
+		item.Status = "conflict"
+		item.Message = "New name conflicts with another file in this batch"
+	}
+	seen[item.NewName] = true
+
+	// b. Check against file system (unless it's the same file)
+	if exists {
+		item.Status = "conflict"
+		item.Message = "Target filename already exists"
+	} else if !changed {
+		item.Status = "ok" // No change
+		item.Message = ""
+	}
+}
+
+// suffixConflict gives a conflicting item the first free "name (n).ext".
+// The item stays a conflict if there is none.
+func (p *plan) suffixConflict(item *design.PreviewItem, dir string, seen map[string]bool) {
+	name, err := fs.FreeName(dir, item.NewName, func(n string) bool { return seen[n] })
+	if err != nil {
+		return
+	}
+	seen[name] = true
+	item.NewName = name
+	item.Status = "ok"
+	item.Message = "Renamed with a suffix to avoid a conflict"
+	item.Diff = diffNames(item.OriginalName, name)
+}
+
+// parallelFor calls fn(i) for i in [0, n) using at most workers goroutines.
+func parallelFor(n, workers int, fn func(i int)) {
+	if n < 64 || workers <= 1 {
+		// Not worth the goroutines for small batches
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+	var wg sync.WaitGroup
+	next := make(chan int, n)
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	for w := 0; w < min(workers, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
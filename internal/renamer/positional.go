@@ -0,0 +1,94 @@
+package renamer
+
+import (
+	"nas-renamer/design"
+	"path/filepath"
+	"strings"
+)
+
+// applyPositional runs a positional rule on the name without its extension.
+// Indexes are in runes so CJK names work, and are clamped to the name length.
+func applyPositional(name string, rule design.RenameRule) string {
+	ext := filepath.Ext(name)
+	runes := []rune(strings.TrimSuffix(name, ext))
+
+	var out []rune
+	switch rule.Type {
+	case design.RuleInsert:
+		at := position(len(runes), rule.Start, rule.FromEnd)
+		out = append(out, runes[:at]...)
+		out = append(out, []rune(rule.Target)...)
+		out = append(out, runes[at:]...)
+	case design.RuleDelete:
+		// Unlike keep, a missing count is not "to the end": that would wipe the name
+		if rule.Count <= 0 {
+			return name
+		}
+		start, end := span(len(runes), rule.Start, rule.Count, rule.FromEnd)
+		out = append(out, runes[:start]...)
+		out = append(out, runes[end:]...)
+	case design.RuleKeep:
+		start, end := span(len(runes), rule.Start, rule.Count, rule.FromEnd)
+		out = runes[start:end]
+	case design.RuleRemoveWords:
+		out = removeWords(runes, rule.Count, rule.FromEnd)
+	default:
+		return name
+	}
+
+	// Never produce an empty name, leave the file alone instead
+	if len(out) == 0 {
+		return name
+	}
+	return string(out) + ext
+}
+
+// position converts a start index to an offset from the beginning.
+func position(length, start int, fromEnd bool) int {
+	if fromEnd {
+		start = length - start
+	}
+	return max(0, min(start, length))
+}
+
+// span returns [start, end) for count runes at start. count <= 0 means to the
+// end, which only keep uses.
+func span(length, start, count int, fromEnd bool) (int, int) {
+	s := position(length, start, fromEnd)
+	if count <= 0 {
+		return s, length
+	}
+	return s, min(s+count, length)
+}
+
+func isWordDelim(r rune) bool {
+	return r == ' ' || r == '.' || r == '_' || r == '-'
+}
+
+// removeWords drops n words and the delimiters that separated them.
+func removeWords(runes []rune, n int, fromEnd bool) []rune {
+	// Collect word spans
+	type word struct{ start, end int }
+	var words []word
+	for i := 0; i < len(runes); {
+		if isWordDelim(runes[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(runes) && !isWordDelim(runes[i]) {
+			i++
+		}
+		words = append(words, word{start, i})
+	}
+	if n <= 0 || len(words) == 0 {
+		return runes
+	}
+	if n >= len(words) {
+		return nil
+	}
+	if fromEnd {
+		return runes[:words[len(words)-n-1].end]
+	}
+	return runes[words[n].start:]
+}
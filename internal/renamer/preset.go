@@ -0,0 +1,33 @@
+package renamer
+
+import "nas-renamer/design"
+
+// ApplyPreset fills the rule fields of req from preset p, then applies
+// req.Overrides on top. The inline rule fields of req are replaced.
+func ApplyPreset(req *design.RenameRequest, p *design.Preset) {
+	req.Mode = p.Mode
+	req.QuickRules = p.QuickRules
+	req.CustomRules = append([]design.RenameRule(nil), p.CustomRules...)
+	req.Template = p.Template
+	req.Filters = p.Filters
+
+	o := req.Overrides
+	if o == nil {
+		return
+	}
+	if o.Mode != "" {
+		req.Mode = o.Mode
+	}
+	if o.QuickRules != nil {
+		req.QuickRules = *o.QuickRules
+	}
+	if o.CustomRules != nil {
+		req.CustomRules = o.CustomRules
+	}
+	if o.Template != nil {
+		req.Template = *o.Template
+	}
+	if o.Filters != nil {
+		req.Filters = *o.Filters
+	}
+}
@@ -0,0 +1,84 @@
+package renamer
+
+import (
+	"nas-renamer/design"
+)
+
+// PreviewEach computes the preview like ComputePreview but hands items to emit
+// as soon as they are ready, in order. Returning an error from emit stops the
+// preview and is returned as is. When req.Trace is set it also returns how many
+// files each step changed.
+func (e *Engine) PreviewEach(req *design.RenameRequest, ignoredExts []string, emit func(design.PreviewItem) error) ([]design.RuleStat, error) {
+	targets, err := e.identifyTargets(req)
+	if err != nil {
+		return nil, err
+	}
+	p, err := e.compilePlan(req, ignoredExts)
+	if err != nil {
+		return nil, err
+	}
+	if !req.Trace {
+		return nil, p.previewEach(targets, emit)
+	}
+	stats := p.ruleStats()
+	err = p.previewEach(targets, func(item design.PreviewItem) error {
+		countRuleStats(stats, item)
+		return emit(item)
+	})
+	return stats, err
+}
+
+// ComputePreviewPage returns the items selected by q, with the total number of
+// matching items and a summary of the whole batch.
+func (e *Engine) ComputePreviewPage(req *design.RenameRequest, ignoredExts []string, q design.PreviewQuery) (*design.PreviewResponse, error) {
+	resp := &design.PreviewResponse{
+		Items:   []design.PreviewItem{},
+		Offset:  q.Offset,
+		Summary: &design.PreviewSummary{},
+	}
+	stats, err := e.PreviewEach(req, ignoredExts, func(item design.PreviewItem) error {
+		CountPreviewItem(resp.Summary, item)
+		if !MatchesPreviewQuery(item, q) {
+			return nil
+		}
+		if resp.Total >= q.Offset && (q.Limit <= 0 || len(resp.Items) < q.Limit) {
+			resp.Items = append(resp.Items, item)
+		}
+		resp.Total++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp.RuleStats = stats
+	return resp, nil
+}
+
+// CountPreviewItem adds item to the summary.
+func CountPreviewItem(s *design.PreviewSummary, item design.PreviewItem) {
+	s.Total++
+	if item.NewName != item.OriginalName {
+		s.Changed++
+	}
+	switch item.Status {
+	case "ok":
+		s.OK++
+	case "conflict":
+		s.Conflict++
+	case "skipped":
+		s.Skipped++
+	case "error":
+		s.Error++
+	}
+}
+
+// MatchesPreviewQuery reports whether item passes the filters of q.
+func MatchesPreviewQuery(item design.PreviewItem, q design.PreviewQuery) bool {
+	if q.Status != "" && item.Status != q.Status {
+		return false
+	}
+	if q.Changed && item.NewName == item.OriginalName {
+		return false
+	}
+	return true
+}
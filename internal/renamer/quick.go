@@ -0,0 +1,436 @@
+package renamer
+
+import (
+	"nas-renamer/design"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// quickPipeline holds quick mode options with their word lists resolved.
+type quickPipeline struct {
+	rules     design.QuickOptions
+	junkWords *wordRegexp      // Literal junk entries, matched between delimiters
+	junkGlued *wordRegexp      // Literal junk entries with CJK text, matched anywhere
+	junk      []*regexp.Regexp // Regex junk entries
+	scene     []*wordRegexp    // One per enabled scene tag category
+	titleTags *wordRegexp      // Enabled scene tags that are also title words
+	group     *wordRegexp      // Trailing -GRP right after a scene tag, nil if disabled
+	urls      *urlDetector     // Nil unless RemoveURL
+	domains   *urlDetector     // Classifies ad brackets, nil unless SmartBrackets
+}
+
+// wordRegexp is a regex built from literal words. Most names contain none of
+// the words, so a cheap substring check runs before the regex engine.
+type wordRegexp struct {
+	re    *regexp.Regexp
+	words []string // Lower-cased
+}
+
+func (w *wordRegexp) mayMatch(lower string) bool {
+	for _, word := range w.words {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeAll strips every match and reports whether anything matched. The
+// delimiters around a bounded word are kept.
+func (w *wordRegexp) removeAll(base string) (string, bool) {
+	found := false
+	// Adjacent words share a delimiter, so repeat until nothing changes
+	for i := 0; i < 8; i++ {
+		var next string
+		if w.re.NumSubexp() == 2 {
+			next = w.re.ReplaceAllString(base, "${1}${2}")
+		} else {
+			next = w.re.ReplaceAllString(base, "")
+		}
+		if next == base {
+			break
+		}
+		found = true
+		base = next
+	}
+	return base, found
+}
+
+// sceneDelims may surround a scene tag. Tags glued to other text are left alone,
+// so "x265" is removed from "Movie.x265.mkv" but "DV" stays in "DVDay".
+const sceneDelims = ` ._\-\[\]()【】（）+@`
+
+// titleWordTags are scene tags that are also ordinary title words, as in
+// "Charlotte's.Web.2006.mkv". They are only removed after the year or the
+// episode marker, where the title has ended.
+var titleWordTags = map[string]bool{
+	"web": true, "remux": true, "nf": true, "dv": true, "dvd": true, "sdr": true, "atmos": true,
+}
+
+// Compiled once, applyQuickRules runs for every file of a batch
+var (
+	squareBracketRe = regexp.MustCompile(`\[.*?\]|【.*?】`)
+	parenRe         = regexp.MustCompile(`\(.*?\)|（.*?）`)
+	spacesRe        = regexp.MustCompile(`\s+`)
+	dotsRe          = regexp.MustCompile(`\.+`)
+	releaseMarkerRe = regexp.MustCompile(`(?i)(?:^|[` + sceneDelims + `])(?:(?:19|20)\d{2}|S\d{1,2}E\d{1,3})(?:[` + sceneDelims + `]|$)`)
+)
+
+func (e *Engine) compileQuickRules(rules design.QuickOptions) (*quickPipeline, error) {
+	p := &quickPipeline{rules: rules}
+	if rules.RemoveKnownJunk && e.lexicon != nil {
+		tokens, err := e.lexicon.GetJunkTokens()
+		if err != nil {
+			return p, err
+		}
+		p.junkWords, p.junkGlued, p.junk = compileJunk(tokens)
+	}
+
+	if rules.RemoveURL || rules.SmartBrackets {
+		tlds := fallbackTLDs
+		if e.lexicon != nil {
+			configured, err := e.lexicon.GetAdTLDs()
+			if err != nil {
+				return p, err
+			}
+			tlds = configured
+		}
+		d := newURLDetector(tlds)
+		if rules.RemoveURL {
+			p.urls = d
+		}
+		if rules.SmartBrackets {
+			p.domains = d
+		}
+	}
+
+	enabled := map[string]bool{
+		design.SceneTagResolution: rules.RemoveResolution,
+		design.SceneTagSource:     rules.RemoveSource,
+		design.SceneTagCodec:      rules.RemoveCodec,
+		design.SceneTagHDR:        rules.RemoveHDR,
+		design.SceneTagGroup:      rules.RemoveGroup,
+		design.SceneTagChinese:    rules.RemoveChineseTags,
+	}
+	anyScene := false
+	for _, on := range enabled {
+		anyScene = anyScene || on
+	}
+	if anyScene && e.lexicon != nil {
+		tags, err := e.lexicon.GetSceneTags()
+		if err != nil {
+			return p, err
+		}
+		var titleWords []string
+		for _, category := range design.SceneTagCategories {
+			if !enabled[category] {
+				continue
+			}
+			// Chinese tags are usually glued to the title, so they match anywhere
+			bounded := category != design.SceneTagChinese
+			words := tags[category]
+			if bounded {
+				words = nil
+				for _, w := range tags[category] {
+					if titleWordTags[strings.ToLower(w)] {
+						titleWords = append(titleWords, w)
+					} else {
+						words = append(words, w)
+					}
+				}
+			}
+			if re := compileTagList(words, bounded); re != nil {
+				p.scene = append(p.scene, re)
+			}
+		}
+		p.titleTags = compileTagList(titleWords, true)
+		if rules.RemoveGroup {
+			p.group = compileGroupSuffix(tags)
+		}
+	}
+	return p, nil
+}
+
+// compileGroupSuffix matches "<tag>-GRP" at the end of a name, optionally
+// followed by an extension. Requiring a scene tag in front keeps "Spider-Man" intact.
+func compileGroupSuffix(tags map[string][]string) *wordRegexp {
+	var words []string
+	for _, category := range design.SceneTagCategories {
+		if category != design.SceneTagChinese && category != design.SceneTagGroup {
+			words = append(words, tags[category]...)
+		}
+	}
+	w := newWordRegexp(words)
+	if w == nil {
+		return nil
+	}
+	w.re = regexp.MustCompile(`(?i)(` + w.re.String() + `)-[A-Za-z0-9]{2,}(\.[A-Za-z][A-Za-z0-9]{1,3})?$`)
+	return w
+}
+
+// compileTagList builds one case-insensitive alternation of literal words.
+// Longer words come first so "HDR10+" wins over "HDR".
+func compileTagList(words []string, bounded bool) *wordRegexp {
+	w := newWordRegexp(words)
+	if w == nil {
+		return nil
+	}
+	if !bounded {
+		w.re = regexp.MustCompile(`(?i)(?:` + w.re.String() + `)`)
+	} else {
+		w.re = regexp.MustCompile(`(?i)(^|[` + sceneDelims + `])(?:` + w.re.String() + `)([` + sceneDelims + `]|$)`)
+	}
+	return w
+}
+
+// newWordRegexp returns the words as a plain alternation, nil if there are none.
+func newWordRegexp(words []string) *wordRegexp {
+	w := &wordRegexp{}
+	var quoted []string
+	for _, word := range words {
+		if word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+			w.words = append(w.words, strings.ToLower(word))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	sort.SliceStable(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+	w.re = regexp.MustCompile(strings.Join(quoted, "|"))
+	return w
+}
+
+// removeSceneTags strips every enabled category and reports whether anything matched.
+func (p *quickPipeline) removeSceneTags(base string) (string, bool) {
+	found := false
+	lower := strings.ToLower(base)
+	if p.group != nil && p.group.mayMatch(lower) && p.group.re.MatchString(base) {
+		found = true
+		base = p.group.re.ReplaceAllString(base, "${1}${2}")
+	}
+	for _, w := range p.scene {
+		if !w.mayMatch(lower) {
+			continue
+		}
+		var removed bool
+		base, removed = w.removeAll(base)
+		found = found || removed
+	}
+	if p.titleTags != nil && p.titleTags.mayMatch(lower) {
+		var removed bool
+		base, removed = p.removeTitleTags(base)
+		found = found || removed
+	}
+	return base, found
+}
+
+// removeTitleTags strips the title word tags that come after a year or an
+// SxxEyy marker. Tags before it are part of the title and stay.
+func (p *quickPipeline) removeTitleTags(base string) (string, bool) {
+	found := false
+	for i := 0; i < 8; i++ {
+		var b strings.Builder
+		last := 0
+		for _, m := range p.titleTags.re.FindAllStringSubmatchIndex(base, -1) {
+			// The tag sits between the two delimiter groups
+			start, end := m[3], m[4]
+			if !releaseMarkerRe.MatchString(base[:start]) {
+				continue
+			}
+			b.WriteString(base[last:start])
+			last = end
+		}
+		if last == 0 {
+			break
+		}
+		b.WriteString(base[last:])
+		found = true
+		base = b.String()
+	}
+	return base, found
+}
+
+// compileJunk turns enabled dictionary entries into regexes. Literals match
+// case-insensitively and share one alternation; invalid regexes are skipped.
+// Like scene tags, literals only match between delimiters, so "cam" stays in
+// "Camera", except those with CJK text, which is usually glued to the title.
+func compileJunk(tokens []design.JunkToken) (*wordRegexp, *wordRegexp, []*regexp.Regexp) {
+	var res []*regexp.Regexp
+	var literals, glued []string
+	for _, t := range tokens {
+		if !t.Enabled || t.Pattern == "" {
+			continue
+		}
+		if !t.Regex {
+			if hasCJK(t.Pattern) {
+				glued = append(glued, t.Pattern)
+			} else {
+				literals = append(literals, t.Pattern)
+			}
+			continue
+		}
+		if re, err := regexp.Compile(t.Pattern); err == nil {
+			res = append(res, re)
+		}
+	}
+	return compileTagList(literals, true), compileTagList(glued, false), res
+}
+
+func hasCJK(s string) bool {
+	for _, r := range s {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			return true
+		}
+	}
+	return false
+}
+
+// isJunk reports whether s matches any junk dictionary entry.
+func (p *quickPipeline) isJunk(s string) bool {
+	lower := strings.ToLower(s)
+	for _, w := range []*wordRegexp{p.junkWords, p.junkGlued} {
+		if w != nil && w.mayMatch(lower) && w.re.MatchString(s) {
+			return true
+		}
+	}
+	for _, re := range p.junk {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+var bracketPairs = map[rune]rune{'[': ']', '【': '】', '(': ')', '（': '）'}
+
+// removeEmptyBrackets drops brackets left empty (or holding only spaces) by an
+// earlier step. Hand written, it runs several times per file.
+func removeEmptyBrackets(s string) string {
+	if !strings.ContainsAny(s, "[【(（") {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if closer, ok := bracketPairs[r]; ok {
+			j := i + size
+			for j < len(s) && unicode.IsSpace(rune(s[j])) {
+				j++
+			}
+			if c, csize := utf8.DecodeRuneInString(s[j:]); c == closer {
+				i = j + csize
+				continue
+			}
+		}
+		sb.WriteString(s[i : i+size])
+		i += size
+	}
+	return sb.String()
+}
+
+func (p *quickPipeline) apply(name string) string {
+	return p.run(name, nil, nil)
+}
+
+// explain applies the rules and also returns notes describing the removals.
+// Steps are recorded to t, which may be nil.
+func (p *quickPipeline) explain(name string, t *tracer) (string, []string) {
+	var notes []string
+	res := p.run(name, &notes, t)
+	return res, notes
+}
+
+func (p *quickPipeline) run(name string, notes *[]string, t *tracer) string {
+	rules := p.rules
+	ext := filepath.Ext(name)
+	base := name
+	if rules.ProtectExtension && ext != "" {
+		base = strings.TrimSuffix(name, ext)
+	}
+	// Keep in sync with steps
+	record := func(step string) {
+		if t == nil {
+			return
+		}
+		if rules.ProtectExtension {
+			t.record(step, -1, base+ext)
+		} else {
+			t.record(step, -1, base)
+		}
+	}
+
+	// 0. Remove known junk tokens, plus brackets they leave empty
+	if p.junkWords != nil || p.junkGlued != nil || len(p.junk) > 0 {
+		lower := strings.ToLower(base)
+		for _, w := range []*wordRegexp{p.junkWords, p.junkGlued} {
+			if w != nil && w.mayMatch(lower) {
+				base, _ = w.removeAll(base)
+			}
+		}
+		for _, re := range p.junk {
+			base = re.ReplaceAllString(base, "")
+		}
+		base = removeEmptyBrackets(base)
+		record(stepKnownJunk)
+	}
+
+	// 0b. Remove release scene tags (resolution, source, codec, ...)
+	if len(p.scene) > 0 || p.titleTags != nil || p.group != nil {
+		var found bool
+		if base, found = p.removeSceneTags(base); found {
+			base = removeEmptyBrackets(base)
+		}
+		record(stepSceneTags)
+	}
+
+	// 1. Remove Brackets [...] 【...】, or only selected kinds of content in smart mode
+	if rules.SmartBrackets {
+		if strings.ContainsAny(base, "[【(（") {
+			base = p.removeSmartBrackets(base, notes)
+		}
+		record(stepSmartBrackets)
+	}
+	if rules.RemoveBrackets && !rules.SmartBrackets {
+		base = squareBracketRe.ReplaceAllString(base, "")
+		record(stepRemoveBrackets)
+	}
+
+	// 2. Remove Parens (...) （...）
+	if rules.RemoveParens && !rules.SmartBrackets {
+		base = parenRe.ReplaceAllString(base, "")
+		record(stepRemoveParens)
+	}
+
+	// 3. Remove URLs, ad domains and ad phrases
+	if p.urls != nil {
+		base = removeEmptyBrackets(p.urls.strip(base))
+		record(stepRemoveURL)
+	}
+
+	// 4. Normalize Delimiters (_ -> .)
+	if rules.NormalizeDelim {
+		base = strings.ReplaceAll(base, "_", ".")
+		record(stepNormalizeDelim)
+	}
+
+	// Cleanup: Trim extra spaces/dots potentially left behind
+	base = strings.TrimSpace(base)
+	if strings.Contains(base, "  ") || strings.ContainsAny(base, "\t\n\v\f\r\u0085\u00a0") {
+		base = spacesRe.ReplaceAllString(base, " ")
+	}
+	if strings.Contains(base, "..") {
+		base = dotsRe.ReplaceAllString(base, ".")
+	}
+	base = strings.Trim(base, ".") // Don't start/end with dot
+	record(stepCleanup)
+
+	if rules.ProtectExtension {
+		return base + ext
+	}
+	return base
+}
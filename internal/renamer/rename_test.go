@@ -1,9 +1,13 @@
 package renamer
 
 import (
+	"errors"
 	"nas-renamer/design"
+	"nas-renamer/internal/config"
+	"nas-renamer/internal/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -255,3 +259,672 @@ func TestIgnoredExtensions(t *testing.T) {
 		}
 	}
 }
+
+func TestPresetTemplateAndFilters(t *testing.T) {
+	engine := NewEngine()
+	tmpDir := t.TempDir()
+
+	for _, name := range []string{"a_1.mkv", "b_2.mkv", "c_3.mp4", "sample.mkv"} {
+		f, _ := os.Create(filepath.Join(tmpDir, name))
+		f.Close()
+	}
+
+	tmpl := "Show - {index:2}{ext}"
+	preset := &design.Preset{
+		Mode:        design.ModeBasic,
+		CustomRules: []design.RenameRule{{Type: "replace", Target: "_", Replacement: "."}},
+		Template:    "{name}{ext}",
+		Filters:     design.FileFilter{Extensions: []string{"mkv"}, Exclude: []string{"sample*"}},
+	}
+	req := &design.RenameRequest{
+		DirPath:   tmpDir,
+		Overrides: &design.PresetOverrides{Template: &tmpl},
+	}
+	ApplyPreset(req, preset)
+
+	preview, err := engine.ComputePreview(req, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	for _, item := range preview.Items {
+		got[item.OriginalName] = item.NewName
+	}
+	expected := map[string]string{
+		"a_1.mkv": "Show - 01.mkv",
+		"b_2.mkv": "Show - 02.mkv",
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d items, got %v", len(expected), got)
+	}
+	for orig, want := range expected {
+		if got[orig] != want {
+			t.Errorf("%s: expected %s, got %s", orig, want, got[orig])
+		}
+	}
+}
+
+func TestNewNameStaysInDir(t *testing.T) {
+	engine := NewEngine()
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "a.mkv"), nil, 0644)
+
+	for _, tmpl := range []string{"../{name}{ext}", `sub\{name}{ext}`, "..", ""} {
+		req := &design.RenameRequest{
+			DirPath:     tmpDir,
+			Mode:        design.ModeBasic,
+			CustomRules: []design.RenameRule{{Type: "replace", Target: "a", Replacement: "b"}},
+			Template:    tmpl,
+		}
+		if tmpl == "" {
+			// Without a template the rules alone empty the name
+			req.CustomRules[0].Target, req.CustomRules[0].Replacement = "a.mkv", ""
+		}
+		preview, err := engine.ComputePreview(req, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item := preview.Items[0]; item.Status != "error" || item.NewName != "a.mkv" {
+			t.Errorf("Template %q: expected an error, got %+v", tmpl, item)
+		}
+	}
+}
+
+func TestTemplateIndexWidth(t *testing.T) {
+	if got := applyTemplate("{index:100000}", "a.mkv", "a.mkv", "/x", 7); len(got) != design.MaxIndexWidth {
+		t.Errorf("Expected the width capped at %d, got %d characters", design.MaxIndexWidth, len(got))
+	}
+	if err := ValidateTemplate("{name} - {index:32}{ext}"); err != nil {
+		t.Errorf("Expected a width of 32 to be valid: %v", err)
+	}
+	if err := ValidateTemplate("{index:33}"); err == nil {
+		t.Error("Expected a width of 33 to be rejected")
+	}
+}
+
+func TestScriptRule(t *testing.T) {
+	engine := NewEngine()
+	tmpDir := t.TempDir()
+
+	for _, name := range []string{"Show.S01E02.1080p.mkv", "Movie.2009.mkv"} {
+		f, _ := os.Create(filepath.Join(tmpDir, name))
+		f.Close()
+	}
+
+	req := &design.RenameRequest{
+		Mode:    design.ModeBasic,
+		DirPath: tmpDir,
+		CustomRules: []design.RenameRule{{
+			Type:   design.RuleScript,
+			Target: `fields.episode != "" ? fields.title + " - E" + fields.episode + ext : undefined_var`,
+		}},
+	}
+
+	preview, err := engine.ComputePreview(req, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range preview.Items {
+		switch item.OriginalName {
+		case "Show.S01E02.1080p.mkv":
+			if item.Status != "ok" || item.NewName != "Show - E02.mkv" {
+				t.Errorf("Expected ok/Show - E02.mkv, got %s/%s (%s)", item.Status, item.NewName, item.Message)
+			}
+		case "Movie.2009.mkv":
+			if item.Status != "error" || !strings.Contains(item.Message, "undefined_var") {
+				t.Errorf("Expected script error for Movie.2009.mkv, got %s: %s", item.Status, item.Message)
+			}
+		}
+	}
+}
+
+func TestPositionalRules(t *testing.T) {
+	engine := NewEngine()
+
+	cases := []struct {
+		name     string
+		input    string
+		rule     design.RenameRule
+		expected string
+	}{
+		{"insert at index", "IMG20230101.jpg", design.RenameRule{Type: design.RuleInsert, Target: "_", Start: 3}, "IMG_20230101.jpg"},
+		{"insert from end", "scan001.pdf", design.RenameRule{Type: design.RuleInsert, Target: "-", Start: 3, FromEnd: true}, "scan-001.pdf"},
+		{"insert cjk", "阿凡达.mkv", design.RenameRule{Type: design.RuleInsert, Target: "·", Start: 1}, "阿·凡达.mkv"},
+		{"delete range", "DSC_0001_edit.jpg", design.RenameRule{Type: design.RuleDelete, Start: 0, Count: 4}, "0001_edit.jpg"},
+		{"delete last runes", "DSC_0001_edit.jpg", design.RenameRule{Type: design.RuleDelete, Start: 5, Count: 5, FromEnd: true}, "DSC_0001.jpg"},
+		{"keep range", "20230101_123456_IMG.jpg", design.RenameRule{Type: design.RuleKeep, Start: 0, Count: 8}, "20230101.jpg"},
+		{"keep cjk", "第一季第二集.mp4", design.RenameRule{Type: design.RuleKeep, Start: 3}, "第二集.mp4"},
+		{"remove first words", "SunMovie AD Avatar.2009.mkv", design.RenameRule{Type: design.RuleRemoveWords, Count: 2}, "Avatar.2009.mkv"},
+		{"remove last words", "Avatar.2009.1080p.x265.mkv", design.RenameRule{Type: design.RuleRemoveWords, Count: 2, FromEnd: true}, "Avatar.2009.mkv"},
+		{"never empty", "Avatar.mkv", design.RenameRule{Type: design.RuleRemoveWords, Count: 5}, "Avatar.mkv"},
+		{"out of range", "abc.txt", design.RenameRule{Type: design.RuleDelete, Start: 10, Count: 2}, "abc.txt"},
+		{"delete without count", "abcdef.txt", design.RenameRule{Type: design.RuleDelete, Start: 2}, "abcdef.txt"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			result := engine.applyCustomRules(c.input, []design.RenameRule{c.rule})
+			if result != c.expected {
+				t.Errorf("Expected %s, got %s", c.expected, result)
+			}
+		})
+	}
+
+	// An inserted path is caught by the preview
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "a.mkv"), nil, 0644)
+	preview, err := engine.ComputePreview(&design.RenameRequest{
+		DirPath:     tmpDir,
+		Mode:        design.ModeBasic,
+		CustomRules: []design.RenameRule{{Type: design.RuleInsert, Target: "../"}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item := preview.Items[0]; item.Status != "error" || item.NewName != "a.mkv" {
+		t.Errorf("Expected an error for ../a.mkv, got %+v", item)
+	}
+}
+
+type fakeLexicon struct {
+	junk  []design.JunkToken
+	scene map[string][]string
+	tlds  []string
+}
+
+func (l *fakeLexicon) GetJunkTokens() ([]design.JunkToken, error) { return l.junk, nil }
+func (l *fakeLexicon) GetSceneTags() (map[string][]string, error) { return l.scene, nil }
+func (l *fakeLexicon) GetAdTLDs() ([]string, error)               { return l.tlds, nil }
+
+func TestRemoveKnownJunk(t *testing.T) {
+	engine := NewEngineWithLexicon(&fakeLexicon{junk: []design.JunkToken{
+		{Pattern: "sunmovie", Enabled: true},
+		{Pattern: `高清\d+`, Regex: true, Enabled: true},
+		{Pattern: "Avatar", Enabled: false},
+	}})
+
+	input := "[SunMovie]Avatar.高清1080.mkv"
+	options := design.QuickOptions{RemoveKnownJunk: true, ProtectExtension: true}
+	expected := "Avatar.mkv"
+	if result := engine.applyQuickRules(input, options); result != expected {
+		t.Errorf("Expected %s, got %s", expected, result)
+	}
+
+	// Without a lexicon the option is a no-op
+	if result := NewEngine().applyQuickRules(input, options); result != input {
+		t.Errorf("Expected %s unchanged, got %s", input, result)
+	}
+
+	// Literals only match between delimiters, CJK literals anywhere
+	engine = NewEngineWithLexicon(&fakeLexicon{junk: []design.JunkToken{
+		{Pattern: "cam", Enabled: true},
+		{Pattern: "电影天堂", Enabled: true},
+	}})
+	cases := map[string]string{
+		"Camera.Obscura.mkv":    "Camera.Obscura.mkv",
+		"Scam.Artist.cam.mkv":   "Scam.Artist.mkv",
+		"[CAM]Movie.mkv":        "Movie.mkv",
+		"电影天堂阿凡达.mkv":           "阿凡达.mkv",
+		"Webcam.Girls.2004.mkv": "Webcam.Girls.2004.mkv",
+	}
+	for input, expected := range cases {
+		if result := engine.applyQuickRules(input, options); result != expected {
+			t.Errorf("%s: expected %s, got %s", input, expected, result)
+		}
+	}
+}
+
+func TestRemoveSceneTags(t *testing.T) {
+	engine := NewEngineWithLexicon(&fakeLexicon{scene: map[string][]string{
+		design.SceneTagResolution: {"1080p", "4K"},
+		design.SceneTagSource:     {"WEB", "WEB-DL", "BluRay"},
+		design.SceneTagCodec:      {"x265", "HEVC", "AAC"},
+		design.SceneTagHDR:        {"HDR", "HDR10+", "DV"},
+		design.SceneTagChinese:    {"国语中字", "高清"},
+	}})
+	all := design.QuickOptions{
+		ProtectExtension:  true,
+		RemoveResolution:  true,
+		RemoveSource:      true,
+		RemoveCodec:       true,
+		RemoveHDR:         true,
+		RemoveGroup:       true,
+		RemoveChineseTags: true,
+	}
+
+	cases := []struct {
+		input    string
+		options  design.QuickOptions
+		expected string
+	}{
+		{"Movie.2009.1080p.WEB-DL.x265.AAC-GRP.mkv", all, "Movie.2009.mkv"},
+		{"Movie.2009.4K.HDR10+.DV.BluRay.mkv", all, "Movie.2009.mkv"},
+		{"Spider-Man.2002.1080p.mkv", all, "Spider-Man.2002.mkv"},
+		{"阿凡达[国语中字].高清.mkv", all, "阿凡达.mkv"},
+		{"DVDay.Webster.mkv", all, "DVDay.Webster.mkv"},
+		// Title words are tags only once the title has ended
+		{"Charlotte's.Web.2006.1080p.WEB.mkv", all, "Charlotte's.Web.2006.mkv"},
+		{"Show.DV.S01E02.DV.WEB.mkv", all, "Show.DV.S01E02.mkv"},
+		// Only the selected category is removed
+		{"Movie.1080p.x265.mkv", design.QuickOptions{ProtectExtension: true, RemoveCodec: true}, "Movie.1080p.mkv"},
+	}
+	for _, c := range cases {
+		if result := engine.applyQuickRules(c.input, c.options); result != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.input, c.expected, result)
+		}
+	}
+}
+
+func TestRemoveSceneTagsDefaults(t *testing.T) {
+	engine := NewEngineWithLexicon(&fakeLexicon{scene: config.DefaultSceneTags})
+	all := design.QuickOptions{
+		ProtectExtension: true,
+		RemoveResolution: true,
+		RemoveSource:     true,
+		RemoveCodec:      true,
+		RemoveHDR:        true,
+	}
+
+	cases := map[string]string{
+		"Charlotte's.Web.2006.mkv":                 "Charlotte's.Web.2006.mkv",
+		"Charlotte's Web (2006) 1080p WEB-DL.mkv":  "Charlotte's Web (2006).mkv",
+		"The.Remux.Atmos.DVD.mkv":                  "The.Remux.Atmos.DVD.mkv",
+		"Movie.2019.2160p.NF.WEB.DV.Atmos.mkv":     "Movie.2019.mkv",
+		"Show.S02E05.1080p.DVD.Remux.SDR.x264.mkv": "Show.S02E05.mkv",
+	}
+	for input, expected := range cases {
+		if result := engine.applyQuickRules(input, all); result != expected {
+			t.Errorf("%s: expected %s, got %s", input, expected, result)
+		}
+	}
+}
+
+func TestSmartBrackets(t *testing.T) {
+	engine := NewEngine()
+
+	cases := []struct {
+		input      string
+		categories []string
+		expected   string
+	}{
+		// Our own test_data file keeps its year
+		{"Avatar (2009).mp4", []string{design.BracketResolution, design.BracketAd, design.BracketOther}, "Avatar (2009).mp4"},
+		{"Avatar (2009) [1080p].mp4", []string{design.BracketResolution}, "Avatar (2009).mp4"},
+		{"[SubsPlease] Frieren - [01] [1080p][A1B2C3D4].mkv", []string{design.BracketGroup, design.BracketResolution, design.BracketCRC}, "Frieren - [01].mkv"},
+		{"[SunMovie]进击的巨人[第01集][简繁].mkv", []string{design.BracketGroup, design.BracketLanguage}, "进击的巨人[第01集].mkv"},
+		{"Movie【www.xxx.com】(2010).mkv", []string{design.BracketAd}, "Movie(2010).mkv"},
+		{"[sunmovie.cc] ep.mkv", []string{design.BracketAd}, "ep.mkv"},
+		// "me" is not an ad TLD, so this is a group name
+		{"[Trust.Me] ep.mkv", []string{design.BracketAd}, "[Trust.Me] ep.mkv"},
+	}
+	for _, c := range cases {
+		p, _ := engine.compileQuickRules(design.QuickOptions{
+			SmartBrackets:     true,
+			BracketCategories: c.categories,
+			RemoveBrackets:    true, // Ignored in smart mode
+			ProtectExtension:  true,
+		})
+		result, notes := p.explain(c.input, nil)
+		if result != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.input, c.expected, result)
+		}
+		if result != c.input && len(notes) == 0 {
+			t.Errorf("%s: expected removal notes", c.input)
+		}
+	}
+
+	// Ad brackets use the lexicon's TLDs
+	engine = NewEngineWithLexicon(&fakeLexicon{tlds: []string{"top"}})
+	p, _ := engine.compileQuickRules(design.QuickOptions{SmartBrackets: true, BracketCategories: []string{design.BracketAd}})
+	for input, expected := range map[string]string{
+		"Avatar [bbs.movie.top].mkv": "Avatar .mkv",
+		"Avatar [sunmovie.cc].mkv":   "Avatar [sunmovie.cc].mkv",
+	} {
+		if result, _ := p.explain(input, nil); result != expected {
+			t.Errorf("%s: expected %s, got %s", input, expected, result)
+		}
+	}
+}
+
+func TestRemoveURL(t *testing.T) {
+	engine := NewEngineWithLexicon(&fakeLexicon{tlds: []string{"com", "net", "cn", "com.cn", "me", "tv", "top"}})
+
+	cases := []struct {
+		input    string
+		protect  bool
+		expected string
+	}{
+		// Our own test_data file
+		{"www.Inception.mkv", true, "Inception.mkv"},
+		{"www.Inception.mkv", false, "Inception.mkv"},
+		{"www.xxx.com_Video1.avi", true, "_Video1.avi"},
+		{"[www.sunmovie.com.cn]Avatar.mkv", true, "Avatar.mkv"},
+		{"sunmovie.com@Avatar.2009.mkv", true, "Avatar.2009.mkv"},
+		{"Avatar.2009.[bbs.movie.top].mkv", true, "Avatar.2009.mkv"},
+		{"xxx.net - Avatar.mkv", true, "Avatar.mkv"},
+		{"Avatar https://example.com/a?b=1 2009.mkv", true, "Avatar 2009.mkv"},
+		{"更多资源请访问：xxx.net 阿凡达.mkv", true, "阿凡达.mkv"},
+		{"WWW.SITE.COM.Avatar.mkv", true, "Avatar.mkv"},
+		// Legitimate names must survive
+		{"Dr.Who.S01E01.mkv", true, "Dr.Who.S01E01.mkv"},
+		{"Mr.Robot.S01E01.mkv", false, "Mr.Robot.S01E01.mkv"},
+		{"Trust.Me.2013.mkv", true, "Trust.Me.2013.mkv"},
+		{"Comedy.Central.mkv", true, "Comedy.Central.mkv"},
+		// Without an ad signal a domain-like title is kept, even in lowercase
+		{"Avatar.2009.bbs.movie.top.mkv", true, "Avatar.2009.bbs.movie.top.mkv"},
+		{"trust.me.2013.mkv", true, "trust.me.2013.mkv"},
+		{"dr.who.tv.s01e01.mkv", true, "dr.who.tv.s01e01.mkv"},
+	}
+	for _, c := range cases {
+		options := design.QuickOptions{RemoveURL: true, ProtectExtension: c.protect}
+		if result := engine.applyQuickRules(c.input, options); result != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.input, c.expected, result)
+		}
+	}
+
+	// Without a lexicon the original TLDs are used
+	if result := NewEngine().applyQuickRules("xxx.com@Avatar.mkv", design.QuickOptions{RemoveURL: true}); result != "Avatar.mkv" {
+		t.Errorf("Expected Avatar.mkv, got %s", result)
+	}
+
+	// Titles made of words that are also TLDs survive the default list
+	defaults := NewEngineWithLexicon(&fakeLexicon{tlds: config.DefaultAdTLDs})
+	for _, name := range []string{"lost.in.translation.2003.mkv", "the.office.us.s01e01.mkv", "trust.me.2013.mkv"} {
+		if result := defaults.applyQuickRules(name, design.QuickOptions{RemoveURL: true, ProtectExtension: true}); result != name {
+			t.Errorf("%s: expected it unchanged, got %s", name, result)
+		}
+	}
+}
+
+func TestComputePreviewPage(t *testing.T) {
+	engine := NewEngine()
+	tmpDir := t.TempDir()
+
+	// a_1 -> a.1 and b_1 -> b.1 change, "c.1.txt" exists so c_1 conflicts, d.txt stays
+	for _, name := range []string{"a_1.txt", "b_1.txt", "c_1.txt", "c.1.txt", "d.txt"} {
+		f, _ := os.Create(filepath.Join(tmpDir, name))
+		f.Close()
+	}
+	req := &design.RenameRequest{
+		DirPath:     tmpDir,
+		Mode:        design.ModeBasic,
+		CustomRules: []design.RenameRule{{Type: "replace", Target: "_", Replacement: "."}},
+	}
+
+	page, err := engine.ComputePreviewPage(req, nil, design.PreviewQuery{Changed: true, Offset: 1, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 3 {
+		t.Errorf("Expected 3 changed items, got %d", page.Total)
+	}
+	if len(page.Items) != 1 || page.Items[0].OriginalName != "b_1.txt" {
+		t.Errorf("Expected page [b_1.txt], got %+v", page.Items)
+	}
+	want := design.PreviewSummary{Total: 5, Changed: 3, OK: 4, Conflict: 1}
+	if *page.Summary != want {
+		t.Errorf("Expected summary %+v, got %+v", want, *page.Summary)
+	}
+
+	page, err = engine.ComputePreviewPage(req, nil, design.PreviewQuery{Status: "conflict"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || page.Items[0].OriginalName != "c_1.txt" {
+		t.Errorf("Expected only c_1.txt, got %+v", page.Items)
+	}
+}
+
+func TestDiffNames(t *testing.T) {
+	spans := diffNames("[SubsPlease] 進撃の巨人 - 01.mkv", "進撃の巨人 - S01E01.mkv")
+	var oldName, newName strings.Builder
+	for _, s := range spans {
+		if s.Op != design.DiffInsert {
+			oldName.WriteString(s.Text)
+		}
+		if s.Op != design.DiffDelete {
+			newName.WriteString(s.Text)
+		}
+	}
+	if oldName.String() != "[SubsPlease] 進撃の巨人 - 01.mkv" || newName.String() != "進撃の巨人 - S01E01.mkv" {
+		t.Fatalf("Spans do not rebuild both names: %+v", spans)
+	}
+
+	want := []design.DiffSpan{
+		{Op: design.DiffDelete, Text: "[SubsPlease] ", Old: 0, New: 0},
+		{Op: design.DiffKeep, Text: "進撃の巨人 - ", Old: 13, New: 0},
+		{Op: design.DiffInsert, Text: "S01E", Old: 21, New: 8},
+		{Op: design.DiffKeep, Text: "01.mkv", Old: 21, New: 12},
+	}
+	if len(spans) != len(want) {
+		t.Fatalf("Expected %+v, got %+v", want, spans)
+	}
+	for i := range want {
+		if spans[i] != want[i] {
+			t.Errorf("Span %d: expected %+v, got %+v", i, want[i], spans[i])
+		}
+	}
+
+	if diffNames("same.mkv", "same.mkv") != nil {
+		t.Error("Expected no spans for an unchanged name")
+	}
+}
+
+func TestPreviewTrace(t *testing.T) {
+	engine := NewEngine()
+	tmpDir := t.TempDir()
+	for _, name := range []string{"a_b.txt", "c.txt"} {
+		f, _ := os.Create(filepath.Join(tmpDir, name))
+		f.Close()
+	}
+
+	req := &design.RenameRequest{
+		DirPath: tmpDir,
+		Mode:    design.ModeBasic,
+		CustomRules: []design.RenameRule{
+			{Type: "replace", Target: "_", Replacement: "-"},
+			{Type: "replace", Target: "zzz", Replacement: ""},
+			{Type: "prefix", Target: "x "},
+		},
+		Trace: true,
+	}
+	preview, err := engine.ComputePreview(req, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trace := preview.Items[0].Trace
+	wantNames := []string{"a-b.txt", "a-b.txt", "x a-b.txt"}
+	wantChanged := []bool{true, false, true}
+	if len(trace) != len(wantNames) {
+		t.Fatalf("Expected %d steps, got %+v", len(wantNames), trace)
+	}
+	for i := range wantNames {
+		if trace[i].Name != wantNames[i] || trace[i].Changed != wantChanged[i] || trace[i].Index != i {
+			t.Errorf("Step %d: got %+v", i, trace[i])
+		}
+	}
+
+	wantAffected := []int{1, 0, 2}
+	if len(preview.RuleStats) != len(wantAffected) {
+		t.Fatalf("Expected %d rule stats, got %+v", len(wantAffected), preview.RuleStats)
+	}
+	for i, want := range wantAffected {
+		if preview.RuleStats[i].Affected != want {
+			t.Errorf("Rule %d: expected %d affected, got %d", i, want, preview.RuleStats[i].Affected)
+		}
+	}
+
+	// Quick mode traces the enabled options only
+	req = &design.RenameRequest{
+		DirPath:    tmpDir,
+		Mode:       design.ModeQuick,
+		QuickRules: design.QuickOptions{NormalizeDelim: true, ProtectExtension: true},
+		Template:   "{name} - {index}{ext}",
+		Trace:      true,
+	}
+	preview, err = engine.ComputePreview(req, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rules []string
+	for _, step := range preview.Items[0].Trace {
+		rules = append(rules, step.Rule)
+	}
+	if strings.Join(rules, ",") != "normalize_delim,cleanup,template" {
+		t.Errorf("Unexpected quick steps %v", rules)
+	}
+	if len(preview.RuleStats) != 3 || preview.RuleStats[0].Affected != 1 || preview.RuleStats[1].Affected != 0 || preview.RuleStats[2].Affected != 2 {
+		t.Errorf("Unexpected quick rule stats %+v", preview.RuleStats)
+	}
+}
+
+func TestConflictSuffix(t *testing.T) {
+	engine := NewEngine()
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "a_1.txt"), []byte("new"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "a.1.txt"), []byte("old"), 0644)
+
+	req := &design.RenameRequest{
+		DirPath:     tmpDir,
+		Mode:        design.ModeBasic,
+		CustomRules: []design.RenameRule{{Type: "replace", Target: "_", Replacement: "."}},
+		OnConflict:  design.ConflictSuffix,
+	}
+	preview, err := engine.ComputePreview(req, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var item design.PreviewItem
+	for _, it := range preview.Items {
+		if it.OriginalName == "a_1.txt" {
+			item = it
+		}
+	}
+	if item.Status != "ok" || item.NewName != "a.1 (1).txt" {
+		t.Fatalf("Expected a suffixed name, got %+v", item)
+	}
+
+	resp, log, err := engine.ExecuteRename(req, ExecuteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.SuccessCount != 1 || len(log.Items) != 1 || log.Items[0].NewName != "a.1 (1).txt" {
+		t.Fatalf("Unexpected result %+v, %+v", resp, log.Items)
+	}
+	if data, _ := os.ReadFile(filepath.Join(tmpDir, "a.1.txt")); string(data) != "old" {
+		t.Error("Existing file was replaced")
+	}
+}
+
+func TestExecuteDirectoryBusy(t *testing.T) {
+	engine := NewEngine()
+	tmpDir := t.TempDir()
+	req := &design.RenameRequest{DirPath: tmpDir, Mode: design.ModeBasic}
+
+	unlock, err := fs.LockDir(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := engine.ExecuteRename(req, ExecuteOptions{}); !errors.Is(err, fs.ErrDirectoryBusy) {
+		t.Errorf("Expected ErrDirectoryBusy, got %v", err)
+	}
+	unlock()
+	if _, _, err := engine.ExecuteRename(req, ExecuteOptions{}); err != nil {
+		t.Errorf("Expected the lock to be released, got %v", err)
+	}
+}
+
+// takeNameLog is an IntentLog that creates a file at the target of one item
+// just before it is renamed, so that rename fails.
+type takeNameLog struct {
+	dir   string
+	index int
+}
+
+func (l *takeNameLog) Begin(*design.HistoryLog, []design.HistoryItem) error { return nil }
+func (l *takeNameLog) Done(string, int, string) error                       { return nil }
+func (l *takeNameLog) Intend(_ string, index int, item design.HistoryItem) error {
+	if index == l.index {
+		return os.WriteFile(filepath.Join(l.dir, item.NewName), []byte("taken"), 0644)
+	}
+	return nil
+}
+
+// targetLog is an IntentLog that creates file take once the batch begins,
+// after the preview, and keeps the target of every intend record.
+type targetLog struct {
+	take    string
+	targets []string
+}
+
+func (l *targetLog) Begin(*design.HistoryLog, []design.HistoryItem) error {
+	return os.WriteFile(l.take, []byte("taken"), 0644)
+}
+func (l *targetLog) Done(string, int, string) error { return nil }
+func (l *targetLog) Intend(_ string, _ int, item design.HistoryItem) error {
+	l.targets = append(l.targets, item.NewName)
+	return nil
+}
+
+func TestExecuteLogsSuffixedTarget(t *testing.T) {
+	engine := NewEngine()
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "a_1.txt"), []byte("a"), 0644)
+	req := &design.RenameRequest{
+		DirPath:     tmpDir,
+		Mode:        design.ModeBasic,
+		CustomRules: []design.RenameRule{{Type: "replace", Target: "_", Replacement: "-"}},
+		OnConflict:  design.ConflictSuffix,
+	}
+
+	intents := &targetLog{take: filepath.Join(tmpDir, "a-1.txt")}
+	resp, log, err := engine.ExecuteRename(req, ExecuteOptions{Intents: intents})
+	if err != nil || resp.SuccessCount != 1 {
+		t.Fatalf("Expected one rename, got %+v %v", resp, err)
+	}
+	if len(intents.targets) != 1 || intents.targets[0] != "a-1 (1).txt" || log.Items[0].NewName != "a-1 (1).txt" {
+		t.Fatalf("Expected a-1 (1).txt logged and recorded, got %v and %+v", intents.targets, log.Items)
+	}
+}
+
+func TestExecuteAtomicRollback(t *testing.T) {
+	engine := NewEngine()
+	tmpDir := t.TempDir()
+	for _, name := range []string{"a_1.txt", "b_1.txt", "c_1.txt"} {
+		os.WriteFile(filepath.Join(tmpDir, name), []byte(name), 0644)
+	}
+	req := &design.RenameRequest{
+		DirPath:     tmpDir,
+		Mode:        design.ModeBasic,
+		CustomRules: []design.RenameRule{{Type: "replace", Target: "_", Replacement: "-"}},
+		Atomic:      true,
+	}
+
+	resp, log, err := engine.ExecuteRename(req, ExecuteOptions{Intents: &takeNameLog{dir: tmpDir, index: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.RolledBack || resp.SuccessCount != 0 || resp.FailCount != 1 || len(log.Items) != 0 {
+		t.Fatalf("Expected a full rollback, got %+v with %d history items", resp, len(log.Items))
+	}
+	for _, name := range []string{"a_1.txt", "b_1.txt", "c_1.txt"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, name)); err != nil {
+			t.Errorf("Expected %s back: %v", name, err)
+		}
+	}
+
+	// A batch with a conflict in the preview is not started at all
+	os.Remove(filepath.Join(tmpDir, "c-1.txt"))
+	os.WriteFile(filepath.Join(tmpDir, "b-1.txt"), nil, 0644)
+	resp, log, err = engine.ExecuteRename(req, ExecuteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.SuccessCount != 0 || len(log.Items) != 0 {
+		t.Fatalf("Expected nothing renamed, got %+v", resp)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "a_1.txt")); err != nil {
+		t.Errorf("Expected a_1.txt untouched: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package renamer
+
+import (
+	"fmt"
+	"nas-renamer/design"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Template placeholders:
+//
+//	{name}      current name (after rules) without extension
+//	{ext}       extension including the dot
+//	{original}  original name without extension
+//	{parent}    name of the containing directory
+//	{index}     1-based position in the batch, {index:3} pads to 3 digits,
+//	            up to design.MaxIndexWidth
+var placeholderRe = regexp.MustCompile(`\{(name|ext|original|parent|index)(?::(\d+))?\}`)
+
+// applyTemplate renders tmpl for a file. An empty template returns name unchanged.
+func applyTemplate(tmpl, name, originalName, dirPath string, index int) string {
+	if tmpl == "" {
+		return name
+	}
+	ext := filepath.Ext(name)
+	return placeholderRe.ReplaceAllStringFunc(tmpl, func(m string) string {
+		parts := placeholderRe.FindStringSubmatch(m)
+		switch parts[1] {
+		case "name":
+			return strings.TrimSuffix(name, ext)
+		case "ext":
+			return ext
+		case "original":
+			return strings.TrimSuffix(originalName, filepath.Ext(originalName))
+		case "parent":
+			return filepath.Base(dirPath)
+		case "index":
+			width, _ := strconv.Atoi(parts[2])
+			width = min(width, design.MaxIndexWidth)
+			return fmt.Sprintf("%0*d", width, index)
+		}
+		return m
+	})
+}
+
+// ValidateTemplate rejects an {index:N} wider than design.MaxIndexWidth.
+func ValidateTemplate(tmpl string) error {
+	for _, parts := range placeholderRe.FindAllStringSubmatch(tmpl, -1) {
+		if parts[1] != "index" || parts[2] == "" {
+			continue
+		}
+		if width, err := strconv.Atoi(parts[2]); err != nil || width > design.MaxIndexWidth {
+			return fmt.Errorf("template: {index:%s} is wider than %d digits", parts[2], design.MaxIndexWidth)
+		}
+	}
+	return nil
+}
+
+// matchesFilter reports whether a file name passes the filter.
+func matchesFilter(name string, f design.FileFilter) bool {
+	if len(f.Extensions) > 0 {
+		ext := filepath.Ext(name)
+		found := false
+		for _, e := range f.Extensions {
+			if strings.EqualFold(ext, e) || strings.EqualFold(ext, "."+e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Include) > 0 {
+		found := false
+		for _, pattern := range f.Include {
+			if ok, _ := filepath.Match(pattern, name); ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, pattern := range f.Exclude {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package renamer
+
+import (
+	"nas-renamer/design"
+)
+
+// Step names of quick mode and the template in a trace
+const (
+	stepKnownJunk      = "remove_known_junk"
+	stepSceneTags      = "remove_scene_tags"
+	stepSmartBrackets  = "smart_brackets"
+	stepRemoveBrackets = "remove_brackets"
+	stepRemoveParens   = "remove_parens"
+	stepRemoveURL      = "remove_url"
+	stepNormalizeDelim = "normalize_delim"
+	stepCleanup        = "cleanup"
+	stepTemplate       = "template"
+)
+
+// tracer records the name after each step. A nil tracer records nothing,
+// so pipelines call it unconditionally.
+type tracer struct {
+	steps []design.TraceStep
+	last  string
+}
+
+func newTracer(name string) *tracer {
+	return &tracer{last: name}
+}
+
+func (t *tracer) record(rule string, index int, name string) {
+	if t == nil {
+		return
+	}
+	t.steps = append(t.steps, design.TraceStep{
+		Rule:    rule,
+		Index:   index,
+		Name:    name,
+		Changed: name != t.last,
+	})
+	t.last = name
+}
+
+// result returns the recorded steps, nil for a nil tracer.
+func (t *tracer) result() []design.TraceStep {
+	if t == nil {
+		return nil
+	}
+	return t.steps
+}
+
+// steps lists the quick options that run, in the order run records them.
+func (p *quickPipeline) steps() []design.RuleStat {
+	var stats []design.RuleStat
+	add := func(rule string) {
+		stats = append(stats, design.RuleStat{Rule: rule, Index: -1})
+	}
+	rules := p.rules
+	if p.junkWords != nil || p.junkGlued != nil || len(p.junk) > 0 {
+		add(stepKnownJunk)
+	}
+	if len(p.scene) > 0 || p.titleTags != nil || p.group != nil {
+		add(stepSceneTags)
+	}
+	switch {
+	case rules.SmartBrackets:
+		add(stepSmartBrackets)
+	default:
+		if rules.RemoveBrackets {
+			add(stepRemoveBrackets)
+		}
+		if rules.RemoveParens {
+			add(stepRemoveParens)
+		}
+	}
+	if p.urls != nil {
+		add(stepRemoveURL)
+	}
+	if rules.NormalizeDelim {
+		add(stepNormalizeDelim)
+	}
+	add(stepCleanup)
+	return stats
+}
+
+// ruleStats returns one zeroed entry per traced step of the plan.
+func (p *plan) ruleStats() []design.RuleStat {
+	var stats []design.RuleStat
+	if p.quick != nil {
+		stats = p.quick.steps()
+	} else {
+		for i, rule := range p.custom.rules {
+			stats = append(stats, design.RuleStat{Rule: rule.Type, Index: i})
+		}
+	}
+	if p.req.Template != "" {
+		stats = append(stats, design.RuleStat{Rule: stepTemplate, Index: -1})
+	}
+	return stats
+}
+
+// countRuleStats adds the steps that changed item to stats.
+func countRuleStats(stats []design.RuleStat, item design.PreviewItem) {
+	for i, step := range item.Trace {
+		if i < len(stats) && step.Changed {
+			stats[i].Affected++
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package renamer
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Used when the engine has no lexicon; the original RemoveURL TLDs minus "me",
+// which is an ordinary word in titles.
+var fallbackTLDs = []string{"com", "net", "org", "cn", "cc", "io", "xyz"}
+
+// adPhrases are Chinese lead-ins that usually precede an ad domain.
+var adPhrases = []string{
+	"更多资源请访问", "更多精彩请访问", "更多电影请访问", "更多高清请访问", "更多资源请关注",
+	"本资源来自", "本片由", "首发于", "下载地址", "高清电影下载", "电影天堂", "最新网址",
+}
+
+// urlDetector finds URLs, ad domains and ad phrases in a name.
+type urlDetector struct {
+	full    *regexp.Regexp // http(s)://...
+	www     *regexp.Regexp // www.example.com, www.Example
+	at      *regexp.Regexp // example.com@
+	bracket *regexp.Regexp // [example.com], 【example.com】
+	leading *regexp.Regexp // example.com_Name, example.com Name
+	inner   *regexp.Regexp // A known TLD anywhere, for bracket content
+	phrases *regexp.Regexp
+	dotTLDs []string // ".com", ... for a cheap check before the domain regex
+}
+
+var fullURLRe = regexp.MustCompile(`(?i)https?://[^\s\[\]()【】（）]+`)
+
+func newURLDetector(tlds []string) *urlDetector {
+	d := &urlDetector{full: fullURLRe}
+	var quoted []string
+	for _, t := range tlds {
+		t = strings.TrimPrefix(strings.TrimSpace(t), ".")
+		if t != "" {
+			quoted = append(quoted, regexp.QuoteMeta(strings.ToLower(t)))
+			d.dotTLDs = append(d.dotTLDs, "."+strings.ToLower(t))
+		}
+	}
+	// Longest first, so "com.cn" wins over "com"
+	sort.SliceStable(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+	tldAlt := strings.Join(quoted, "|")
+
+	phrases := make([]string, len(adPhrases))
+	for i, p := range adPhrases {
+		phrases[i] = regexp.QuoteMeta(p)
+	}
+
+	d.phrases = regexp.MustCompile(`(?:` + strings.Join(phrases, "|") + `)[：:\s]*`)
+	if tldAlt == "" {
+		d.www = regexp.MustCompile(`(?i)\bwww\.()`)
+		d.inner = bracketURLRe
+		return d
+	}
+	// "www." is a strong signal: take the whole domain if it has a known TLD,
+	// otherwise only the prefix ("www.Inception.mkv" -> "Inception.mkv").
+	// The last group is the delimiter after the domain, which is kept.
+	d.www = regexp.MustCompile(`(?i)\bwww\.(?:(?:[a-z0-9-]+\.)+(?:` + tldAlt + `)(?:@|([^a-z0-9@]|$)))?`)
+	// A bare domain is only removed where an ad puts it: before "@", in
+	// brackets, or at the start followed by a space, "_" or "-".
+	// Titles such as "lost.in.translation" look like domains otherwise.
+	domain := `(?:[a-z0-9][a-z0-9-]*\.)+(?:` + tldAlt + `)`
+	d.at = regexp.MustCompile(`(?i)(^|[^a-z0-9.-])` + domain + `@`)
+	d.bracket = regexp.MustCompile(`(?i)[\[【(（]\s*` + domain + `\s*[\]】)）]`)
+	// The TLD must be lowercase here, so "Trust.Me - Pilot" is kept
+	d.leading = regexp.MustCompile(`^(?i:(?:[a-z0-9][a-z0-9-]*\.)+)(?:` + tldAlt + `)[\s_-]+`)
+	d.inner = regexp.MustCompile(`(?i)www\.|https?://|\.(?:` + tldAlt + `)(?:[^a-z]|$)`)
+	return d
+}
+
+// isAd reports whether bracket content is a URL or names an ad domain.
+func (d *urlDetector) isAd(content string) bool {
+	return d.inner.MatchString(content)
+}
+
+// strip removes every detected URL, domain and ad phrase from s.
+// Each regex only runs when a substring check says it can match.
+func (d *urlDetector) strip(s string) string {
+	if strings.Contains(s, "://") {
+		s = d.full.ReplaceAllString(s, "")
+	}
+	if !isASCII(s) { // Ad phrases are all CJK
+		s = d.phrases.ReplaceAllString(s, "")
+	}
+	lower := strings.ToLower(s)
+	if strings.Contains(lower, "www.") {
+		s = d.www.ReplaceAllString(s, "${1}")
+		lower = strings.ToLower(s)
+	}
+	if d.at != nil {
+		for _, tld := range d.dotTLDs {
+			if strings.Contains(lower, tld) {
+				s = d.at.ReplaceAllString(s, "${1}")
+				s = d.bracket.ReplaceAllString(s, "")
+				s = d.leading.ReplaceAllString(s, "")
+				break
+			}
+		}
+	}
+	return s
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
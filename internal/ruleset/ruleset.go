@@ -0,0 +1,188 @@
+// Package ruleset reads and writes portable rule set documents, so presets
+// can be shared between installations as JSON or YAML files.
+package ruleset
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"nas-renamer/design"
+	"nas-renamer/internal/script"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
+
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+var ErrNotRuleSet = errors.New("document is not a nas-renamer rule set")
+
+// New wraps presets in a document of the current schema version.
+// Server-side fields (IDs, usage stats) are cleared since they mean nothing elsewhere.
+func New(presets []design.Preset) *design.RuleSetDocument {
+	doc := &design.RuleSetDocument{
+		Kind:          design.RuleSetKind,
+		SchemaVersion: design.RuleSetSchemaVersion,
+		ExportedAt:    time.Now().Unix(),
+		Presets:       make([]design.Preset, 0, len(presets)),
+	}
+	for _, p := range presets {
+		p.ID = ""
+		p.Version = 0
+		p.CreatedAt = 0
+		p.UpdatedAt = 0
+		p.LastUsedAt = 0
+		doc.Presets = append(doc.Presets, p)
+	}
+	return doc
+}
+
+// Encode serializes doc in the given format (json or yaml).
+func Encode(doc *design.RuleSetDocument, format string) ([]byte, error) {
+	switch format {
+	case FormatJSON, "":
+		return json.MarshalIndent(doc, "", "  ")
+	case FormatYAML, "yml":
+		return yaml.Marshal(doc)
+	}
+	return nil, fmt.Errorf("unsupported format: %s", format)
+}
+
+// Decode parses a document. An empty format is detected from the content.
+// Unknown fields do not fail decoding, they are reported as warnings.
+func Decode(data []byte, format string) (*design.RuleSetDocument, []design.ImportWarning, error) {
+	if format == "" {
+		format = DetectFormat(data)
+	}
+
+	var doc design.RuleSetDocument
+	var strictErr error
+	switch format {
+	case FormatJSON:
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, nil, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		strictErr = dec.Decode(&design.RuleSetDocument{})
+	case FormatYAML, "yml":
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, nil, err
+		}
+		strictErr = yaml.UnmarshalWithOptions(data, &design.RuleSetDocument{}, yaml.DisallowUnknownField())
+	default:
+		return nil, nil, fmt.Errorf("unsupported format: %s", format)
+	}
+
+	if doc.Kind != "" && doc.Kind != design.RuleSetKind {
+		return nil, nil, fmt.Errorf("%w: kind is %q", ErrNotRuleSet, doc.Kind)
+	}
+
+	var warnings []design.ImportWarning
+	if strictErr != nil {
+		warnings = append(warnings, design.ImportWarning{
+			Rule:    -1,
+			Message: "Document contains fields this server does not know, they will be ignored: " + strictErr.Error(),
+		})
+	}
+	return &doc, warnings, nil
+}
+
+// DetectFormat guesses json or yaml from the first non-blank character.
+func DetectFormat(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return FormatJSON
+	}
+	return FormatYAML
+}
+
+// Validate checks doc and returns the presets that can be imported plus
+// warnings for everything else. Unknown rule types are kept as they are,
+// the engine skips them, so a newer server can still use them after a round trip.
+func Validate(doc *design.RuleSetDocument) ([]design.Preset, []design.ImportWarning) {
+	var warnings []design.ImportWarning
+
+	switch {
+	case doc.SchemaVersion == 0:
+		warnings = append(warnings, design.ImportWarning{
+			Rule:     -1,
+			Message:  "Missing schema_version",
+			Blocking: true,
+		})
+	case doc.SchemaVersion > design.RuleSetSchemaVersion:
+		warnings = append(warnings, design.ImportWarning{
+			Rule: -1,
+			Message: fmt.Sprintf("Document uses schema version %d, this server understands up to %d. Settings added in newer versions will be lost",
+				doc.SchemaVersion, design.RuleSetSchemaVersion),
+			Blocking: true,
+		})
+	}
+
+	var valid []design.Preset
+	for _, p := range doc.Presets {
+		name := p.Name
+		if name == "" {
+			warnings = append(warnings, design.ImportWarning{Rule: -1, Message: "Skipped a preset without a name"})
+			continue
+		}
+		switch p.Mode {
+		case design.ModeQuick, design.ModeBasic, design.ModeAdvanced:
+		default:
+			warnings = append(warnings, design.ImportWarning{
+				Preset:  name,
+				Rule:    -1,
+				Message: fmt.Sprintf("Skipped: unknown mode %q", p.Mode),
+			})
+			continue
+		}
+
+		for i, rule := range p.CustomRules {
+			if !slices.Contains(design.KnownRuleTypes, rule.Type) {
+				warnings = append(warnings, design.ImportWarning{
+					Preset:  name,
+					Rule:    i,
+					Message: fmt.Sprintf("Unknown rule type %q, kept but it has no effect on this server", rule.Type),
+				})
+				continue
+			}
+			switch rule.Type {
+			case design.RuleRegex:
+				if _, err := regexp.Compile(rule.Target); err != nil {
+					warnings = append(warnings, design.ImportWarning{
+						Preset:  name,
+						Rule:    i,
+						Message: "Invalid regex: " + strings.TrimPrefix(err.Error(), "error parsing regexp: "),
+					})
+				}
+			case design.RuleScript:
+				if _, err := script.Compile(rule.Target); err != nil {
+					warnings = append(warnings, design.ImportWarning{
+						Preset:  name,
+						Rule:    i,
+						Message: "Invalid script: " + err.Error(),
+					})
+				}
+			}
+		}
+		valid = append(valid, p)
+	}
+	return valid, warnings
+}
+
+// HasBlocking reports whether any warning prevents an import.
+func HasBlocking(warnings []design.ImportWarning) bool {
+	for _, w := range warnings {
+		if w.Blocking {
+			return true
+		}
+	}
+	return false
+}
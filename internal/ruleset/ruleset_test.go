@@ -0,0 +1,65 @@
+package ruleset
+
+import (
+	"nas-renamer/design"
+	"testing"
+)
+
+func TestRoundTripYAML(t *testing.T) {
+	presets := []design.Preset{{
+		ID:   "local-id",
+		Name: "Anime cleanup",
+		Mode: design.ModeBasic,
+		CustomRules: []design.RenameRule{
+			{Type: design.RuleRegex, Target: `\[.*?\]`, Replacement: ""},
+			{Type: "future_rule", Target: "x"},
+		},
+		Template: "{name}{ext}",
+		Filters:  design.FileFilter{Extensions: []string{".mkv"}},
+	}}
+
+	data, err := Encode(New(presets), FormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, warnings, err := Decode(data, "")
+	if err != nil {
+		t.Fatalf("Decode failed: %v\n%s", err, data)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("Expected no decode warnings, got %v", warnings)
+	}
+
+	valid, warnings := Validate(doc)
+	if len(valid) != 1 {
+		t.Fatalf("Expected 1 valid preset, got %d", len(valid))
+	}
+	if valid[0].ID != "" {
+		t.Errorf("Expected exported ID to be cleared, got %s", valid[0].ID)
+	}
+	if len(valid[0].CustomRules) != 2 || valid[0].CustomRules[1].Type != "future_rule" {
+		t.Errorf("Unknown rule type should be kept, got %+v", valid[0].CustomRules)
+	}
+	if len(warnings) != 1 || warnings[0].Rule != 1 || warnings[0].Blocking {
+		t.Errorf("Expected one non-blocking warning for rule 1, got %+v", warnings)
+	}
+}
+
+func TestNewerSchemaIsBlocking(t *testing.T) {
+	data := []byte(`{"kind": "nas-renamer/ruleset", "schema_version": 99, "presets": [{"name": "x", "mode": "quick", "shiny": true}]}`)
+	doc, warnings, err := Decode(data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("Expected unknown field warning, got %+v", warnings)
+	}
+	_, more := Validate(doc)
+	if !HasBlocking(more) {
+		t.Errorf("Expected newer schema version to block the import, got %+v", more)
+	}
+
+	if _, _, err := Decode([]byte(`{"kind": "something-else"}`), ""); err == nil {
+		t.Error("Expected foreign document to be rejected")
+	}
+}
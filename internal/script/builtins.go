@@ -0,0 +1,230 @@
+package script
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type builtin func(m *vm, args []any) (any, error)
+
+var builtins map[string]builtin
+
+func init() {
+	builtins = map[string]builtin{
+		"len": func(m *vm, args []any) (any, error) {
+			s, err := stringArgs(args, 1)
+			if err != nil {
+				return nil, err
+			}
+			return float64(len([]rune(s[0]))), nil
+		},
+		"lower":       stringFunc(strings.ToLower),
+		"upper":       stringFunc(strings.ToUpper),
+		"trim":        stringFunc(strings.TrimSpace),
+		"strip_cjk":   stringFunc(func(s string) string { return stripRunes(s, isCJK) }),
+		"strip_latin": stringFunc(func(s string) string { return stripRunes(s, isLatin) }),
+		"has_cjk":     stringPred(func(s string) bool { return strings.IndexFunc(s, isCJK) >= 0 }),
+		"has_latin":   stringPred(func(s string) bool { return strings.IndexFunc(s, isLatin) >= 0 }),
+		"contains":    stringPred2(strings.Contains),
+		"starts_with": stringPred2(strings.HasPrefix),
+		"ends_with":   stringPred2(strings.HasSuffix),
+		"index": func(m *vm, args []any) (any, error) {
+			s, err := stringArgs(args, 2)
+			if err != nil {
+				return nil, err
+			}
+			i := strings.Index(s[0], s[1])
+			if i < 0 {
+				return float64(-1), nil
+			}
+			return float64(len([]rune(s[0][:i]))), nil
+		},
+		"replace": func(m *vm, args []any) (any, error) {
+			s, err := stringArgs(args, 3)
+			if err != nil {
+				return nil, err
+			}
+			return strings.ReplaceAll(s[0], s[1], s[2]), nil
+		},
+		"substr": builtinSubstr,
+		"match": func(m *vm, args []any) (any, error) {
+			s, err := stringArgs(args, 2)
+			if err != nil {
+				return nil, err
+			}
+			re, err := m.prog.regex(s[1])
+			if err != nil {
+				return nil, err
+			}
+			return re.MatchString(s[0]), nil
+		},
+		"find": func(m *vm, args []any) (any, error) {
+			s, err := stringArgs(args, 2)
+			if err != nil {
+				return nil, err
+			}
+			re, err := m.prog.regex(s[1])
+			if err != nil {
+				return nil, err
+			}
+			sub := re.FindStringSubmatch(s[0])
+			switch {
+			case sub == nil:
+				return "", nil
+			case len(sub) > 1: // First capture group if the pattern has one
+				return sub[1], nil
+			}
+			return sub[0], nil
+		},
+		"replace_re": func(m *vm, args []any) (any, error) {
+			s, err := stringArgs(args, 3)
+			if err != nil {
+				return nil, err
+			}
+			re, err := m.prog.regex(s[1])
+			if err != nil {
+				return nil, err
+			}
+			return re.ReplaceAllString(s[0], s[2]), nil
+		},
+		"str": func(m *vm, args []any) (any, error) {
+			if len(args) != 1 {
+				return nil, fmt.Errorf("expects 1 argument, got %d", len(args))
+			}
+			return toString(args[0]), nil
+		},
+		"num": func(m *vm, args []any) (any, error) {
+			s, err := stringArgs(args, 1)
+			if err != nil {
+				return nil, err
+			}
+			f, err := strconv.ParseFloat(strings.TrimSpace(s[0]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("not a number: %q", s[0])
+			}
+			return f, nil
+		},
+		"pad": func(m *vm, args []any) (any, error) {
+			if len(args) != 2 {
+				return nil, fmt.Errorf("expects 2 arguments, got %d", len(args))
+			}
+			n, ok1 := args[0].(float64)
+			w, ok2 := args[1].(float64)
+			if !ok1 || !ok2 {
+				return nil, fmt.Errorf("expects (number, number)")
+			}
+			if w > 32 {
+				w = 32
+			}
+			return fmt.Sprintf("%0*d", int(w), int64(n)), nil
+		},
+	}
+}
+
+func stringArgs(args []any, n int) ([]string, error) {
+	if len(args) != n {
+		return nil, fmt.Errorf("expects %d arguments, got %d", n, len(args))
+	}
+	out := make([]string, n)
+	for i, a := range args {
+		s, ok := a.(string)
+		if !ok {
+			return nil, fmt.Errorf("argument %d must be a string, got %s", i+1, typeName(a))
+		}
+		out[i] = s
+	}
+	return out, nil
+}
+
+func stringFunc(f func(string) string) builtin {
+	return func(m *vm, args []any) (any, error) {
+		s, err := stringArgs(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return f(s[0]), nil
+	}
+}
+
+func stringPred(f func(string) bool) builtin {
+	return func(m *vm, args []any) (any, error) {
+		s, err := stringArgs(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return f(s[0]), nil
+	}
+}
+
+func stringPred2(f func(string, string) bool) builtin {
+	return func(m *vm, args []any) (any, error) {
+		s, err := stringArgs(args, 2)
+		if err != nil {
+			return nil, err
+		}
+		return f(s[0], s[1]), nil
+	}
+}
+
+// substr(s, start[, end]) works on runes. Negative indexes count from the end.
+func builtinSubstr(m *vm, args []any) (any, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, fmt.Errorf("expects 2 or 3 arguments, got %d", len(args))
+	}
+	s, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("argument 1 must be a string, got %s", typeName(args[0]))
+	}
+	runes := []rune(s)
+	bound := func(v any) (int, error) {
+		f, ok := v.(float64)
+		if !ok {
+			return 0, fmt.Errorf("index must be a number, got %s", typeName(v))
+		}
+		i := int(f)
+		if i < 0 {
+			i += len(runes)
+		}
+		return max(0, min(i, len(runes))), nil
+	}
+	start, err := bound(args[1])
+	if err != nil {
+		return nil, err
+	}
+	end := len(runes)
+	if len(args) == 3 {
+		if end, err = bound(args[2]); err != nil {
+			return nil, err
+		}
+	}
+	if start >= end {
+		return "", nil
+	}
+	return string(runes[start:end]), nil
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+		(r >= 0x3000 && r <= 0x303F) || (r >= 0xFF00 && r <= 0xFFEF) // CJK and fullwidth punctuation
+}
+
+func isLatin(r rune) bool {
+	return r < unicode.MaxLatin1 && unicode.IsLetter(r)
+}
+
+// stripRunes removes runes matching drop, then tidies the separators left behind.
+func stripRunes(s string, drop func(rune) bool) string {
+	s = strings.Map(func(r rune) rune {
+		if drop(r) {
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.Join(strings.Fields(s), " ")
+	for strings.Contains(s, "..") {
+		s = strings.ReplaceAll(s, "..", ".")
+	}
+	return strings.Trim(s, " ._-")
+}
@@ -0,0 +1,262 @@
+package script
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+type vm struct {
+	prog     *Program
+	scope    map[string]any
+	limits   Limits
+	deadline time.Time
+	steps    int
+}
+
+func (m *vm) step() error {
+	m.steps++
+	if m.steps > m.limits.MaxSteps {
+		return ErrStepLimit
+	}
+	// Reading the clock on every node is wasteful, scripts are tiny
+	if m.steps%64 == 0 && time.Now().After(m.deadline) {
+		return ErrTimeout
+	}
+	return nil
+}
+
+func (m *vm) eval(n node) (any, error) {
+	if err := m.step(); err != nil {
+		return nil, err
+	}
+
+	switch n := n.(type) {
+	case *literal:
+		return n.val, nil
+
+	case *ident:
+		v, ok := m.scope[n.name]
+		if !ok {
+			return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("unknown variable %q", n.name)}
+		}
+		return v, nil
+
+	case *member:
+		obj, err := m.eval(n.obj)
+		if err != nil {
+			return nil, err
+		}
+		fields, ok := obj.(map[string]any)
+		if !ok {
+			return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("%s has no fields", typeName(obj))}
+		}
+		v, ok := fields[n.name]
+		if !ok {
+			return "", nil // Missing parsed fields read as empty
+		}
+		return v, nil
+
+	case *let:
+		val, err := m.eval(n.val)
+		if err != nil {
+			return nil, err
+		}
+		outer := m.scope
+		inner := make(map[string]any, len(outer)+1)
+		for k, v := range outer {
+			inner[k] = v
+		}
+		inner[n.name] = val
+		m.scope = inner
+		defer func() { m.scope = outer }()
+		return m.eval(n.body)
+
+	case *cond:
+		test, err := m.eval(n.test)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(test) {
+			return m.eval(n.yes)
+		}
+		return m.eval(n.no)
+
+	case *unary:
+		x, err := m.eval(n.x)
+		if err != nil {
+			return nil, err
+		}
+		if n.op == "!" {
+			return !truthy(x), nil
+		}
+		f, ok := x.(float64)
+		if !ok {
+			return nil, &Error{Pos: n.pos, Msg: "cannot negate " + typeName(x)}
+		}
+		return -f, nil
+
+	case *binary:
+		return m.evalBinary(n)
+
+	case *call:
+		fn, ok := builtins[n.fn]
+		if !ok {
+			return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("unknown function %q", n.fn)}
+		}
+		args := make([]any, len(n.args))
+		for i, a := range n.args {
+			v, err := m.eval(a)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = v
+		}
+		v, err := fn(m, args)
+		if err != nil {
+			if _, ok := err.(*Error); ok || err == ErrStepLimit || err == ErrTimeout {
+				return nil, err
+			}
+			return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("%s: %v", n.fn, err)}
+		}
+		return m.checkLen(n.pos, v)
+	}
+	return nil, fmt.Errorf("unknown node %T", n)
+}
+
+func (m *vm) evalBinary(n *binary) (any, error) {
+	l, err := m.eval(n.l)
+	if err != nil {
+		return nil, err
+	}
+	// Short-circuit logic operators
+	switch n.op {
+	case "&&":
+		if !truthy(l) {
+			return false, nil
+		}
+		r, err := m.eval(n.r)
+		return truthy(r), err
+	case "||":
+		if truthy(l) {
+			return true, nil
+		}
+		r, err := m.eval(n.r)
+		return truthy(r), err
+	}
+
+	r, err := m.eval(n.r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return l == r, nil
+	case "!=":
+		return l != r, nil
+	case "+":
+		lf, lok := l.(float64)
+		rf, rok := r.(float64)
+		if lok && rok {
+			return lf + rf, nil
+		}
+		return m.checkLen(n.pos, toString(l)+toString(r))
+	}
+
+	// Remaining operators need two numbers, or two strings for comparisons
+	if ls, ok := l.(string); ok {
+		if rs, ok := r.(string); ok {
+			switch n.op {
+			case "<":
+				return ls < rs, nil
+			case "<=":
+				return ls <= rs, nil
+			case ">":
+				return ls > rs, nil
+			case ">=":
+				return ls >= rs, nil
+			}
+		}
+	}
+	lf, lok := l.(float64)
+	rf, rok := r.(float64)
+	if !lok || !rok {
+		return nil, &Error{Pos: n.pos, Msg: fmt.Sprintf("cannot apply %s to %s and %s", n.op, typeName(l), typeName(r))}
+	}
+	switch n.op {
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/", "%":
+		if rf == 0 {
+			return nil, &Error{Pos: n.pos, Msg: "division by zero"}
+		}
+		if n.op == "/" {
+			return lf / rf, nil
+		}
+		return math.Mod(lf, rf), nil
+	case "<":
+		return lf < rf, nil
+	case "<=":
+		return lf <= rf, nil
+	case ">":
+		return lf > rf, nil
+	case ">=":
+		return lf >= rf, nil
+	}
+	return nil, &Error{Pos: n.pos, Msg: "unknown operator " + n.op}
+}
+
+func (m *vm) checkLen(pos int, v any) (any, error) {
+	if s, ok := v.(string); ok && len(s) > m.limits.MaxStringLen {
+		return nil, &Error{Pos: pos, Msg: fmt.Sprintf("string longer than %d bytes", m.limits.MaxStringLen)}
+	}
+	return v, nil
+}
+
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case float64:
+		return v != 0
+	case map[string]any:
+		return len(v) > 0
+	}
+	return false
+}
+
+func toString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "bool"
+	case map[string]any:
+		return "object"
+	case nil:
+		return "nothing"
+	}
+	return fmt.Sprintf("%T", v)
+}
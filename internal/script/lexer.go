@@ -0,0 +1,117 @@
+package script
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string  // identifier, operator or decoded string literal
+	num  float64 // tokNumber only
+	pos  int     // byte offset in source, for error messages
+}
+
+// Two-character operators must come before their one-character prefixes.
+var operators = []string{
+	"==", "!=", "<=", ">=", "&&", "||",
+	"+", "-", "*", "/", "%", "<", ">", "!", "?", ":", "(", ")", "{", "}", ",", ".", "=", ";",
+}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(src) {
+		r, size := utf8.DecodeRuneInString(src[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '#': // Comment until end of line
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case r == '_' || unicode.IsLetter(r):
+			start := i
+			for i < len(src) {
+				r, size = utf8.DecodeRuneInString(src[i:])
+				if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				i += size
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[start:i], pos: start})
+		case r >= '0' && r <= '9':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			n, err := strconv.ParseFloat(src[start:i], 64)
+			if err != nil {
+				return nil, &Error{Pos: start, Msg: fmt.Sprintf("invalid number %q", src[start:i])}
+			}
+			tokens = append(tokens, token{kind: tokNumber, num: n, pos: start})
+		case r == '"' || r == '\'':
+			s, n, err := lexString(src[i:], byte(r))
+			if err != nil {
+				return nil, &Error{Pos: i, Msg: err.Error()}
+			}
+			tokens = append(tokens, token{kind: tokString, text: s, pos: i})
+			i += n
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, &Error{Pos: i, Msg: fmt.Sprintf("unexpected character %q", r)}
+			}
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+// lexString decodes a quoted literal and returns it with the number of bytes consumed.
+func lexString(src string, quote byte) (string, int, error) {
+	var sb strings.Builder
+	for i := 1; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == quote:
+			return sb.String(), i + 1, nil
+		case c == '\\' && i+1 < len(src):
+			i++
+			switch src[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			default: // \\ \" \' and regex escapes like \d are kept literally
+				if src[i] != quote && src[i] != '\\' {
+					sb.WriteByte('\\')
+				}
+				sb.WriteByte(src[i])
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
@@ -0,0 +1,310 @@
+package script
+
+import "fmt"
+
+// AST nodes. Evaluation lives in eval.go.
+type node interface{ position() int }
+
+type (
+	literal struct {
+		pos int
+		val any
+	}
+	ident struct {
+		pos  int
+		name string
+	}
+	member struct {
+		pos  int
+		obj  node
+		name string
+	}
+	call struct {
+		pos  int
+		fn   string
+		args []node
+	}
+	unary struct {
+		pos int
+		op  string
+		x   node
+	}
+	binary struct {
+		pos  int
+		op   string
+		l, r node
+	}
+	cond struct {
+		pos           int
+		test, yes, no node
+	}
+	let struct {
+		pos  int
+		name string
+		val  node
+		body node
+	}
+)
+
+func (n *literal) position() int { return n.pos }
+func (n *ident) position() int   { return n.pos }
+func (n *member) position() int  { return n.pos }
+func (n *call) position() int    { return n.pos }
+func (n *unary) position() int   { return n.pos }
+func (n *binary) position() int  { return n.pos }
+func (n *cond) position() int    { return n.pos }
+func (n *let) position() int     { return n.pos }
+
+type parser struct {
+	tokens []token
+	i      int
+}
+
+func (p *parser) peek() token { return p.tokens[p.i] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) isOp(ops ...string) bool {
+	t := p.peek()
+	if t.kind != tokOp {
+		return false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) isKeyword(word string) bool {
+	t := p.peek()
+	return t.kind == tokIdent && t.text == word
+}
+
+func (p *parser) expect(op string) error {
+	t := p.next()
+	if t.kind != tokOp || t.text != op {
+		return p.errorAt(t, fmt.Sprintf("expected %q", op))
+	}
+	return nil
+}
+
+func (p *parser) errorAt(t token, msg string) error {
+	found := t.text
+	switch t.kind {
+	case tokEOF:
+		found = "end of script"
+	case tokNumber:
+		found = "number"
+	case tokString:
+		found = "string"
+	}
+	return &Error{Pos: t.pos, Msg: fmt.Sprintf("%s, found %s", msg, found)}
+}
+
+// program := { "let" IDENT "=" expr ";" } expr
+func (p *parser) parseProgram() (node, error) {
+	if p.isKeyword("let") {
+		pos := p.next().pos
+		name := p.next()
+		if name.kind != tokIdent || reserved[name.text] {
+			return nil, p.errorAt(name, "expected variable name")
+		}
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		val, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(";"); err != nil {
+			return nil, err
+		}
+		body, err := p.parseProgram()
+		if err != nil {
+			return nil, err
+		}
+		return &let{pos: pos, name: name.text, val: val, body: body}, nil
+	}
+	return p.parseExpr()
+}
+
+var reserved = map[string]bool{"let": true, "if": true, "else": true, "true": true, "false": true}
+
+// expr := or [ "?" expr ":" expr ]
+func (p *parser) parseExpr() (node, error) {
+	test, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.isOp("?") {
+		return test, nil
+	}
+	pos := p.next().pos
+	yes, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	no, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return &cond{pos: pos, test: test, yes: yes, no: no}, nil
+}
+
+// Binary operators by precedence, lowest first.
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) parseBinary(level int) (node, error) {
+	if level == len(precedence) {
+		return p.parseUnary()
+	}
+	l, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(precedence[level]...) {
+		op := p.next()
+		r, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		l = &binary{pos: op.pos, op: op.text, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isOp("!", "-") {
+		op := p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unary{pos: op.pos, op: op.text, x: x}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(".") {
+		p.next()
+		name := p.next()
+		if name.kind != tokIdent {
+			return nil, p.errorAt(name, "expected field name")
+		}
+		x = &member{pos: name.pos, obj: x, name: name.text}
+	}
+	return x, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		return &literal{pos: t.pos, val: t.num}, nil
+	case tokString:
+		return &literal{pos: t.pos, val: t.text}, nil
+	case tokIdent:
+		switch t.text {
+		case "true", "false":
+			return &literal{pos: t.pos, val: t.text == "true"}, nil
+		case "if":
+			return p.parseIf(t.pos)
+		}
+		if reserved[t.text] {
+			return nil, p.errorAt(t, "unexpected keyword")
+		}
+		if !p.isOp("(") {
+			return &ident{pos: t.pos, name: t.text}, nil
+		}
+		p.next()
+		var args []node
+		for !p.isOp(")") {
+			if len(args) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		p.next()
+		return &call{pos: t.pos, fn: t.text, args: args}, nil
+	case tokOp:
+		if t.text == "(" {
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		}
+	}
+	return nil, p.errorAt(t, "expected a value")
+}
+
+// if := "if" expr "{" program "}" "else" ( "{" program "}" | if )
+func (p *parser) parseIf(pos int) (node, error) {
+	test, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	yes, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	if !p.isKeyword("else") {
+		return nil, p.errorAt(p.peek(), `expected "else"`)
+	}
+	p.next()
+	var no node
+	if p.isKeyword("if") {
+		no, err = p.parseIf(p.next().pos)
+	} else {
+		no, err = p.parseBlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cond{pos: pos, test: test, yes: yes, no: no}, nil
+}
+
+func (p *parser) parseBlock() (node, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	body, err := p.parseProgram()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
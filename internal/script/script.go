@@ -0,0 +1,128 @@
+// Package script implements the small expression language used by the
+// "script" rename rule. Scripts are pure: they only see the variables passed
+// to Run and a fixed set of string helpers, with no filesystem, network or
+// clock access. Every run is bounded by a step budget and a deadline.
+//
+// Example:
+//
+//	let en = strip_cjk(base);
+//	if has_cjk(base) && has_latin(base) && !contains(lower(name), "anime") {
+//	    en + ext
+//	} else {
+//	    name
+//	}
+package script
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var (
+	ErrStepLimit = errors.New("script exceeded its step limit")
+	ErrTimeout   = errors.New("script exceeded its time limit")
+)
+
+// Error is a compile or runtime error at a byte offset in the source.
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("offset %d: %s", e.Pos, e.Msg)
+}
+
+// Limits bound a single run.
+type Limits struct {
+	MaxSteps     int           // Evaluated nodes, including builtin calls
+	Timeout      time.Duration // Wall clock budget
+	MaxStringLen int           // Longest string a script may build, in bytes
+}
+
+var DefaultLimits = Limits{
+	MaxSteps:     10000,
+	Timeout:      50 * time.Millisecond,
+	MaxStringLen: 4096,
+}
+
+const (
+	maxPatternLen = 512
+	maxRegexCache = 32
+)
+
+// Program is a compiled script. It is safe for concurrent use.
+type Program struct {
+	root node
+
+	mu      sync.Mutex
+	regexes map[string]*regexp.Regexp
+}
+
+// Compile parses src.
+func Compile(src string) (*Program, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseProgram()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorAt(t, "expected end of script")
+	}
+	return &Program{root: root, regexes: make(map[string]*regexp.Regexp)}, nil
+}
+
+// Run evaluates the program. vars values must be string, float64, bool or
+// map[string]any of those.
+func (p *Program) Run(vars map[string]any, limits Limits) (any, error) {
+	vm := &vm{
+		prog:     p,
+		scope:    vars,
+		limits:   limits,
+		deadline: time.Now().Add(limits.Timeout),
+	}
+	return vm.eval(p.root)
+}
+
+// RunString evaluates the program and requires a non-empty string result.
+func (p *Program) RunString(vars map[string]any, limits Limits) (string, error) {
+	v, err := p.Run(vars, limits)
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("script must return a string, got %s", typeName(v))
+	}
+	if s == "" {
+		return "", errors.New("script returned an empty name")
+	}
+	return s, nil
+}
+
+func (p *Program) regex(pattern string) (*regexp.Regexp, error) {
+	if len(pattern) > maxPatternLen {
+		return nil, fmt.Errorf("regex longer than %d bytes", maxPatternLen)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if re, ok := p.regexes[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.regexes) >= maxRegexCache {
+		clear(p.regexes)
+	}
+	p.regexes[pattern] = re
+	return re, nil
+}
@@ -0,0 +1,104 @@
+package script
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRunString(t *testing.T) {
+	src := `
+		# Keep the English title unless it is anime
+		let en = strip_cjk(base);
+		if has_cjk(base) && has_latin(base) && !contains(lower(name), "anime") {
+			en + ext
+		} else {
+			name
+		}`
+	prog, err := Compile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct{ name, expected string }{
+		{"阿凡达.Avatar.2009.mkv", "Avatar.2009.mkv"},
+		{"进击的巨人.Attack.on.Titan.Anime.mkv", "进击的巨人.Attack.on.Titan.Anime.mkv"},
+		{"Inception.mkv", "Inception.mkv"},
+	}
+	for _, c := range cases {
+		dot := strings.LastIndex(c.name, ".")
+		vars := map[string]any{"name": c.name, "base": c.name[:dot], "ext": c.name[dot:]}
+		got, err := prog.RunString(vars, DefaultLimits)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.name, c.expected, got)
+		}
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	vars := map[string]any{
+		"name":   "第03集.mp4",
+		"fields": map[string]any{"episode": "03"},
+		"size":   float64(2048),
+	}
+	cases := []struct{ src, expected string }{
+		{`substr(name, 0, 4)`, "第03集"},
+		{`substr(name, -4)`, ".mp4"},
+		{`"E" + pad(num(fields.episode), 3)`, "E003"},
+		{`find(name, "(\d+)")`, "03"},
+		{`replace_re(name, "^第(\d+)集", "EP$1")`, "EP03.mp4"},
+		{`size > 1024 ? "big" : "small"`, "big"},
+		{`str(len(name))`, "8"},
+		{`fields.missing == "" ? "empty" : "set"`, "empty"},
+	}
+	for _, c := range cases {
+		prog, err := Compile(c.src)
+		if err != nil {
+			t.Errorf("%s: compile: %v", c.src, err)
+			continue
+		}
+		got, err := prog.RunString(vars, DefaultLimits)
+		if err != nil {
+			t.Errorf("%s: %v", c.src, err)
+			continue
+		}
+		if got != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.src, c.expected, got)
+		}
+	}
+}
+
+func TestErrorsAndLimits(t *testing.T) {
+	for _, src := range []string{`name +`, `if x { 1 }`, `"unterminated`, `let if = 1; x`} {
+		if _, err := Compile(src); err == nil {
+			t.Errorf("Expected compile error for %q", src)
+		}
+	}
+
+	prog, _ := Compile(`open("/etc/passwd")`)
+	if _, err := prog.Run(nil, DefaultLimits); err == nil || !strings.Contains(err.Error(), "unknown function") {
+		t.Errorf("Expected unknown function error, got %v", err)
+	}
+
+	// Doubling a string grows it fast enough to hit the length limit
+	prog, _ = Compile(`let a = name + name; let b = a + a; let c = b + b; c + c`)
+	_, err := prog.Run(map[string]any{"name": strings.Repeat("x", 1000)}, DefaultLimits)
+	if err == nil || !strings.Contains(err.Error(), "longer than") {
+		t.Errorf("Expected string length error, got %v", err)
+	}
+
+	prog, _ = Compile(`name + name + name + name`)
+	_, err = prog.Run(map[string]any{"name": "x"}, Limits{MaxSteps: 3, MaxStringLen: 100})
+	if !errors.Is(err, ErrStepLimit) {
+		t.Errorf("Expected step limit error, got %v", err)
+	}
+
+	prog, _ = Compile(`size`)
+	if _, err := prog.RunString(map[string]any{"size": float64(1)}, DefaultLimits); err == nil {
+		t.Error("Expected non-string result to be rejected")
+	}
+}
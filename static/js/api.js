@@ -37,8 +37,10 @@ export const API = {
         return handleResponse(res);
     },
 
-    async previewRename(data) {
-        const res = await fetch(`${API_BASE}/rename/preview`, {
+    async previewRename(data, query = null) {
+        // query: { offset, limit, status, changed } for a paginated, filtered preview
+        const params = query ? `?${new URLSearchParams(query)}` : '';
+        const res = await fetch(`${API_BASE}/rename/preview${params}`, {
             method: 'POST',
             headers: getAuthHeaders(),
             body: JSON.stringify(data)
@@ -46,6 +48,33 @@ export const API = {
         return handleResponse(res);
     },
 
+    // Streams the preview, calling onEvent for every { item | summary, done, error } line
+    async previewRenameStream(data, onEvent, query = null) {
+        const params = query ? `?${new URLSearchParams(query)}` : '';
+        const res = await fetch(`${API_BASE}/rename/preview/stream${params}`, {
+            method: 'POST',
+            headers: getAuthHeaders(),
+            body: JSON.stringify(data)
+        });
+        if (!res.ok) {
+            return handleResponse(res);
+        }
+        const reader = res.body.getReader();
+        const decoder = new TextDecoder();
+        let buffer = '';
+        for (;;) {
+            const { done, value } = await reader.read();
+            if (done) break;
+            buffer += decoder.decode(value, { stream: true });
+            const lines = buffer.split('\n');
+            buffer = lines.pop();
+            for (const line of lines) {
+                if (line.trim()) onEvent(JSON.parse(line));
+            }
+        }
+        if (buffer.trim()) onEvent(JSON.parse(buffer));
+    },
+
     async executeRename(data) {
         const res = await fetch(`${API_BASE}/rename/execute`, {
             method: 'POST',
@@ -55,16 +84,147 @@ export const API = {
         return handleResponse(res);
     },
 
-    async getHistory() {
-        const res = await fetch(`${API_BASE}/history`, { headers: getAuthHeaders() });
+    async getHistory(offset = 0, limit = 0) {
+        const params = limit ? `?${new URLSearchParams({ offset, limit })}` : '';
+        const res = await fetch(`${API_BASE}/history${params}`, { headers: getAuthHeaders() });
         return handleResponse(res);
     },
 
-    async undoHistory(batchId) {
+    // query: path, name, from, to, mode, kind, status, cursor, offset, limit, count.
+    // total is null for filtered queries unless count is set
+    async searchHistory(query = {}) {
+        const params = new URLSearchParams();
+        for (const [key, value] of Object.entries(query)) {
+            if (value !== undefined && value !== null && value !== '') params.set(key, value);
+        }
+        const res = await fetch(`${API_BASE}/history?${params}`, { headers: getAuthHeaders() });
+        const items = await handleResponse(res);
+        const total = res.headers.get('X-Total-Count');
+        return {
+            items,
+            total: total === null ? null : Number(total),
+            nextCursor: res.headers.get('X-Next-Cursor') || ''
+        };
+    },
+
+    async undoHistory(batchId, items = []) {
+        // items: new names of the files to restore, empty for the whole batch
         const res = await fetch(`${API_BASE}/history/undo`, {
             method: 'POST',
             headers: getAuthHeaders(),
-            body: JSON.stringify({ batch_id: batchId })
+            body: JSON.stringify({ batch_id: batchId, items })
+        });
+        return handleResponse(res);
+    },
+
+    async previewUndo(batchId, items = []) {
+        const res = await fetch(`${API_BASE}/history/undo/preview`, {
+            method: 'POST',
+            headers: getAuthHeaders(),
+            body: JSON.stringify({ batch_id: batchId, items })
+        });
+        return handleResponse(res);
+    },
+
+    async redoHistory(batchId, items = []) {
+        const res = await fetch(`${API_BASE}/history/redo`, {
+            method: 'POST',
+            headers: getAuthHeaders(),
+            body: JSON.stringify({ batch_id: batchId, items })
+        });
+        return handleResponse(res);
+    },
+
+    async previewRedo(batchId, items = []) {
+        const res = await fetch(`${API_BASE}/history/redo/preview`, {
+            method: 'POST',
+            headers: getAuthHeaders(),
+            body: JSON.stringify({ batch_id: batchId, items })
+        });
+        return handleResponse(res);
+    },
+
+    async getHistoryLineage(batchId) {
+        const res = await fetch(`${API_BASE}/history/${encodeURIComponent(batchId)}/lineage`, { headers: getAuthHeaders() });
+        return handleResponse(res);
+    },
+
+    async getFileLineage(path) {
+        const res = await fetch(`${API_BASE}/history/file?${new URLSearchParams({ path })}`, { headers: getAuthHeaders() });
+        return handleResponse(res);
+    },
+
+    // name: one of the names in the file's lineage
+    async restoreFileName(path, name, onConflict = '') {
+        const res = await fetch(`${API_BASE}/history/file/restore`, {
+            method: 'POST',
+            headers: getAuthHeaders(),
+            body: JSON.stringify({ path, name, on_conflict: onConflict })
+        });
+        return handleResponse(res);
+    },
+
+    async pinHistory(batchId, pinned) {
+        const res = await fetch(`${API_BASE}/history/${encodeURIComponent(batchId)}/pin`, {
+            method: 'POST',
+            headers: getAuthHeaders(),
+            body: JSON.stringify({ pinned })
+        });
+        return handleResponse(res);
+    },
+
+    // before: Unix seconds, 0 exports everything
+    async exportHistory(before = 0) {
+        const params = before ? `?${new URLSearchParams({ before })}` : '';
+        const res = await fetch(`${API_BASE}/admin/history/export${params}`, { headers: getAuthHeaders() });
+        return handleResponse(res);
+    },
+
+    async deleteHistoryBefore(before) {
+        const res = await fetch(`${API_BASE}/admin/history?${new URLSearchParams({ before })}`, {
+            method: 'DELETE',
+            headers: getAuthHeaders()
+        });
+        return handleResponse(res);
+    },
+
+    // Batches cut short by a crash before their history was saved
+    async getPendingBatches() {
+        const res = await fetch(`${API_BASE}/recovery`, { headers: getAuthHeaders() });
+        return handleResponse(res);
+    },
+
+    async completePendingBatch(batchId) {
+        const res = await fetch(`${API_BASE}/recovery/${encodeURIComponent(batchId)}/complete`, {
+            method: 'POST',
+            headers: getAuthHeaders()
+        });
+        return handleResponse(res);
+    },
+
+    async rollbackPendingBatch(batchId) {
+        const res = await fetch(`${API_BASE}/recovery/${encodeURIComponent(batchId)}/rollback`, {
+            method: 'POST',
+            headers: getAuthHeaders()
+        });
+        return handleResponse(res);
+    },
+
+    async getAuditLog(offset = 0, limit = 0) {
+        const params = limit ? `?${new URLSearchParams({ offset, limit })}` : '';
+        const res = await fetch(`${API_BASE}/admin/audit${params}`, { headers: getAuthHeaders() });
+        return handleResponse(res);
+    },
+
+    async verifyAuditLog() {
+        const res = await fetch(`${API_BASE}/admin/audit/verify`, { headers: getAuthHeaders() });
+        return handleResponse(res);
+    },
+
+    async pruneHistory() {
+        const res = await fetch(`${API_BASE}/admin/history/prune`, {
+            method: 'POST',
+            headers: getAuthHeaders()
         });
         return handleResponse(res);
     },
@@ -89,5 +249,120 @@ export const API = {
         const params = new URLSearchParams({ dir: path });
         const res = await fetch(`${API_BASE}/scan/frequent-strings?${params}`, { headers: getAuthHeaders() });
         return handleResponse(res);
+    },
+
+    // Presets
+    async getPresets() {
+        const res = await fetch(`${API_BASE}/presets`, { headers: getAuthHeaders() });
+        return handleResponse(res);
+    },
+
+    async savePreset(preset) {
+        const url = preset.id ? `${API_BASE}/presets/${preset.id}` : `${API_BASE}/presets`;
+        const res = await fetch(url, {
+            method: preset.id ? 'PUT' : 'POST',
+            headers: getAuthHeaders(),
+            body: JSON.stringify(preset)
+        });
+        return handleResponse(res);
+    },
+
+    async deletePreset(id) {
+        const res = await fetch(`${API_BASE}/presets/${id}`, {
+            method: 'DELETE',
+            headers: getAuthHeaders()
+        });
+        return handleResponse(res);
+    },
+
+    async duplicatePreset(id, name = '') {
+        const res = await fetch(`${API_BASE}/presets/${id}/duplicate`, {
+            method: 'POST',
+            headers: getAuthHeaders(),
+            body: JSON.stringify({ name })
+        });
+        return handleResponse(res);
+    },
+
+    async exportPresets(format = 'json') {
+        const res = await fetch(`${API_BASE}/presets/export?format=${format}`, { headers: getAuthHeaders() });
+        if (!res.ok) return handleResponse(res);
+        return res.blob();
+    },
+
+    async importPresets(text, { dryRun = false, force = false } = {}) {
+        const params = new URLSearchParams({ dry_run: dryRun, force });
+        const res = await fetch(`${API_BASE}/presets/import?${params}`, {
+            method: 'POST',
+            headers: getAuthHeaders(),
+            body: text
+        });
+        return handleResponse(res);
+    },
+
+    // Junk token dictionary
+    async getJunkTokens() {
+        const res = await fetch(`${API_BASE}/config/junk-tokens`, { headers: getAuthHeaders() });
+        return handleResponse(res);
+    },
+
+    async setJunkTokens(tokens) {
+        const res = await fetch(`${API_BASE}/config/junk-tokens`, {
+            method: 'POST',
+            headers: getAuthHeaders(),
+            body: JSON.stringify(tokens)
+        });
+        return handleResponse(res);
+    },
+
+    async promoteJunk({ tokens = [], dirPath = '', category = '' } = {}) {
+        const res = await fetch(`${API_BASE}/config/junk-tokens/promote`, {
+            method: 'POST',
+            headers: getAuthHeaders(),
+            body: JSON.stringify({ tokens, dir_path: dirPath, category })
+        });
+        return handleResponse(res);
+    },
+
+    async getSceneTags() {
+        const res = await fetch(`${API_BASE}/config/scene-tags`, { headers: getAuthHeaders() });
+        return handleResponse(res);
+    },
+
+    async setSceneTags(tags) {
+        const res = await fetch(`${API_BASE}/config/scene-tags`, {
+            method: 'POST',
+            headers: getAuthHeaders(),
+            body: JSON.stringify(tags)
+        });
+        return handleResponse(res);
+    },
+
+    async getAdTLDs() {
+        const res = await fetch(`${API_BASE}/config/ad-tlds`, { headers: getAuthHeaders() });
+        return handleResponse(res);
+    },
+
+    async setAdTLDs(tlds) {
+        const res = await fetch(`${API_BASE}/config/ad-tlds`, {
+            method: 'POST',
+            headers: getAuthHeaders(),
+            body: JSON.stringify(tlds)
+        });
+        return handleResponse(res);
+    },
+
+    async getSettings() {
+        const res = await fetch(`${API_BASE}/config/settings`, { headers: getAuthHeaders() });
+        return handleResponse(res);
+    },
+
+    async setSettings(settings) {
+        const res = await fetch(`${API_BASE}/config/settings`, {
+            method: 'POST',
+            headers: getAuthHeaders(),
+            body: JSON.stringify(settings)
+        });
+        return handleResponse(res);
     }
 };